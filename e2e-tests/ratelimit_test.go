@@ -6,6 +6,7 @@ import (
 	"encoding/json"
 	"io"
 	"net/http"
+	"strconv"
 	"sync"
 	"sync/atomic"
 	"testing"
@@ -26,19 +27,19 @@ func TestRateLimit(t *testing.T) {
 
 	wg := sync.WaitGroup{}
 
-	sendEcho := func() (int, error) {
+	sendEcho := func() (int, http.Header, error) {
 		client := NewRawHTTPClient()
 		body, _ := json.Marshal(&gatewayv1.EchoRequest{Message: "Hey"})
 
 		req, err := http.NewRequest(http.MethodPost, "/echo", bytes.NewReader(body))
 		if err != nil {
-			return 0, err
+			return 0, nil, err
 		}
 		req.Header.Set("Content-Type", "application/json")
 
 		res, err := client.Do(req)
 		if err != nil {
-			return 0, err
+			return 0, nil, err
 		}
 
 		defer func(Body io.ReadCloser) {
@@ -48,7 +49,7 @@ func TestRateLimit(t *testing.T) {
 			}
 		}(res.Body)
 
-		return res.StatusCode, nil
+		return res.StatusCode, res.Header, nil
 	}
 	rateLimitedCount := atomic.Uint64{}
 
@@ -57,16 +58,22 @@ func TestRateLimit(t *testing.T) {
 
 		for range BurstCount {
 			wg.Go(func() {
-				status, err := sendEcho()
+				status, header, err := sendEcho()
 				if err != nil {
 					t.Errorf("Request failed: %v", err)
 					return
 				}
 
+				if status != http.StatusOK && status != http.StatusTooManyRequests {
+					t.Errorf("Unexpected status: %d", status)
+
+					return
+				}
+
+				assertRateLimitHeaders(t, header, Limit, status == http.StatusTooManyRequests)
+
 				if status == http.StatusTooManyRequests {
 					rateLimitedCount.Add(1)
-				} else if status != http.StatusOK {
-					t.Errorf("Unexpected status: %d", status)
 				}
 			})
 		}
@@ -86,3 +93,27 @@ func TestRateLimit(t *testing.T) {
 
 	assert.GreaterOrEqual(t, rateLimitedCount.Load(), uint64(Limit))
 }
+
+func assertRateLimitHeaders(t *testing.T, header http.Header, limit int, isRejected bool) {
+	headerInt := func(name string) int {
+		value, err := strconv.Atoi(header.Get(name))
+		assert.NoError(t, err, "header %s", name)
+
+		return value
+	}
+
+	assert.Equal(t, limit, headerInt("RateLimit-Limit"))
+
+	remaining := headerInt("RateLimit-Remaining")
+	assert.GreaterOrEqual(t, remaining, 0)
+	assert.Less(t, remaining, limit)
+
+	assert.GreaterOrEqual(t, headerInt("RateLimit-Reset"), 0)
+
+	if isRejected {
+		assert.Equal(t, 0, remaining)
+		assert.GreaterOrEqual(t, headerInt("Retry-After"), 1)
+	} else {
+		assert.Empty(t, header.Get("Retry-After"))
+	}
+}
@@ -0,0 +1,202 @@
+package admin
+
+import (
+	"context"
+	"maps"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	"runtime/debug"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"platform/logger"
+	"platform/metric"
+
+	"github.com/caarlos0/env/v11"
+	khttp "github.com/go-kratos/kratos/v2/transport/http"
+	"github.com/goccy/go-json"
+)
+
+type config struct {
+	Addr    string `env:"ADMIN_ADDR" envDefault:":9090"`
+	Network string `env:"ADMIN_NETWORK" envDefault:"tcp"`
+	// CheckTimeout bounds all the readiness checks of a /readyz request.
+	CheckTimeout time.Duration `env:"ADMIN_CHECK_TIMEOUT" envDefault:"2s"`
+}
+
+// Check returns an error when a dependency of the service is not usable, see Server.AddReadinessCheck.
+type Check func(ctx context.Context) error
+
+// Server is the admin server of a service, it must not be reachable from the internet. It serves:
+//
+//	/metrics        the default Prometheus registry
+//	/debug/pprof/*  the net/http/pprof profiles
+//	/healthz        200 while the process runs
+//	/readyz         200 when the service is ready and all its readiness checks pass, 503 otherwise
+//	/buildinfo      the name, version, Go version and VCS settings of the binary
+//	/loglevel       the log level on GET, PUT {"level":"debug"} changes it
+type Server struct {
+	*khttp.Server
+
+	cfg   config
+	ready atomic.Bool
+
+	mu     sync.RWMutex
+	checks map[string]Check
+}
+
+// MustCreateServer creates the admin server listening on ADMIN_ADDR, to add to kratos.App as another server.
+// It is not ready until SetReady, e.g. in the kratos.AfterStart hook.
+func MustCreateServer(name string, version string) *Server {
+	cfg, err := env.ParseAs[config]()
+	if err != nil {
+		logger.Fatal(err.Error())
+
+		return nil
+	}
+
+	s := &Server{
+		Server: khttp.NewServer(
+			khttp.Network(cfg.Network),
+			khttp.Address(cfg.Addr),
+		),
+		cfg:    cfg,
+		checks: make(map[string]Check),
+	}
+
+	metric.RegisterMetricHTTPEndpoint(s.Server)
+
+	s.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	s.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	s.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	s.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	s.HandlePrefix("/debug/pprof/", http.HandlerFunc(pprof.Index))
+
+	s.HandleFunc("/healthz", func(w http.ResponseWriter, _ *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+	})
+	s.HandleFunc("/readyz", s.serveReadiness)
+	s.HandleFunc("/buildinfo", serveBuildInfo(name, version))
+	s.HandleFunc("/loglevel", serveLogLevel)
+
+	return s
+}
+
+// SetReady switches /readyz, e.g. to false in the kratos.BeforeStop hook so the traffic drains before the shutdown.
+func (s *Server) SetReady(ready bool) {
+	s.ready.Store(ready)
+}
+
+// AddReadinessCheck adds a check to /readyz, such as a ping of the database.
+func (s *Server) AddReadinessCheck(name string, check Check) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.checks[name] = check
+}
+
+func (s *Server) serveReadiness(w http.ResponseWriter, r *http.Request) {
+	if !s.ready.Load() {
+		writeJSON(w, http.StatusServiceUnavailable, map[string]string{"status": "not ready"})
+
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), s.cfg.CheckTimeout)
+	defer cancel()
+
+	s.mu.RLock()
+	checks := maps.Clone(s.checks)
+	s.mu.RUnlock()
+
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		failures = make(map[string]string)
+	)
+
+	for name, check := range checks {
+		wg.Go(func() {
+			if err := check(ctx); err != nil {
+				mu.Lock()
+				failures[name] = err.Error()
+				mu.Unlock()
+			}
+		})
+	}
+
+	wg.Wait()
+
+	if len(failures) > 0 {
+		logger.Errorf("readiness checks failed: %v", failures)
+		writeJSON(w, http.StatusServiceUnavailable, map[string]any{"status": "not ready", "failures": failures})
+
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ready"})
+}
+
+func serveBuildInfo(name string, version string) http.HandlerFunc {
+	info := map[string]any{
+		"name":       name,
+		"version":    version,
+		"go_version": runtime.Version(),
+	}
+
+	if build, ok := debug.ReadBuildInfo(); ok {
+		settings := make(map[string]string, len(build.Settings))
+		for _, setting := range build.Settings {
+			settings[setting.Key] = setting.Value
+		}
+
+		info["path"] = build.Path
+		info["settings"] = settings
+	}
+
+	return func(w http.ResponseWriter, _ *http.Request) {
+		writeJSON(w, http.StatusOK, info)
+	}
+}
+
+func serveLogLevel(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut:
+		var body struct {
+			Level string `json:"level"`
+		}
+
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+
+			return
+		}
+
+		if err := logger.SetLevel(body.Level); err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+
+			return
+		}
+
+		logger.Infof("log level changed to %s", body.Level)
+	default:
+		w.Header().Set("Allow", "GET, PUT")
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]string{"level": logger.Level()})
+}
+
+func writeJSON(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		logger.Errorf("error occurred when writing an admin response: %v", err)
+	}
+}
@@ -0,0 +1,191 @@
+package audit
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"platform/logger"
+
+	"github.com/caarlos0/env/v11"
+	"github.com/goccy/go-json"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Record is an audited operation.
+type Record struct {
+	Time      time.Time `json:"time"`
+	Actor     string    `json:"actor"`
+	Operation string    `json:"operation"`
+	RequestID string    `json:"request_id"`
+	// Payload is the request in JSON without its sensitive fields.
+	Payload json.RawMessage `json:"payload"`
+	// Code is the HTTP status of the result, Reason is the reason of the error if any.
+	Code   int32  `json:"code"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// Sink stores the records, see PostgresSink and KafkaSink.
+type Sink interface {
+	Write(ctx context.Context, records []Record) error
+}
+
+type config struct {
+	BufferSize    int           `env:"AUDIT_BUFFER_SIZE" envDefault:"10000"`
+	BatchSize     int           `env:"AUDIT_BATCH_SIZE" envDefault:"100"`
+	FlushInterval time.Duration `env:"AUDIT_FLUSH_INTERVAL" envDefault:"1s"`
+}
+
+type metrics struct {
+	written *prometheus.CounterVec
+}
+
+var getMetrics = sync.OnceValue(func() *metrics {
+	m := &metrics{
+		written: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "audit_records_total",
+				Help: "Number of audit records by result (written, failed, dropped)",
+			},
+			[]string{"result"},
+		),
+	}
+
+	prometheus.MustRegister(m.written)
+
+	return m
+})
+
+// Logger writes the records to a sink asynchronously in batches. Its buffer is bounded:
+// when the sink cannot keep up, the new records are dropped and counted in audit_records_total.
+type Logger struct {
+	sink    Sink
+	cfg     config
+	records chan Record
+	metrics *metrics
+	done    chan struct{}
+
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+// MustCreateLogger creates a Logger with AUDIT_BUFFER_SIZE, AUDIT_BATCH_SIZE and AUDIT_FLUSH_INTERVAL
+// that writes until ctx is done or Close, then flushes the buffered records.
+func MustCreateLogger(ctx context.Context, sink Sink) *Logger {
+	cfg, err := env.ParseAs[config]()
+	if err != nil {
+		logger.Fatal(err.Error())
+
+		return nil
+	}
+
+	if cfg.BufferSize <= 0 || cfg.BatchSize <= 0 || cfg.FlushInterval <= 0 {
+		logger.Fatal("audit buffer size, batch size and flush interval must be positive")
+
+		return nil
+	}
+
+	l := &Logger{
+		sink:    sink,
+		cfg:     cfg,
+		records: make(chan Record, cfg.BufferSize),
+		metrics: getMetrics(),
+		done:    make(chan struct{}),
+		stop:    make(chan struct{}),
+	}
+
+	go l.startWriting(ctx)
+
+	return l
+}
+
+// Log buffers the record, it never blocks.
+func (l *Logger) Log(record Record) {
+	select {
+	case l.records <- record:
+	default:
+		l.metrics.written.WithLabelValues("dropped").Inc()
+	}
+}
+
+// Done is closed when the buffered records are flushed after ctx is done or Close.
+func (l *Logger) Done() <-chan struct{} {
+	return l.done
+}
+
+// Close stops the logger and waits until the buffered records are flushed or ctx is done,
+// e.g. in the kratos.AfterStop hook once the servers do not log anymore.
+func (l *Logger) Close(ctx context.Context) error {
+	l.stopOnce.Do(func() { close(l.stop) })
+
+	select {
+	case <-l.done:
+		return nil
+	case <-ctx.Done():
+		return errors.Wrap(ctx.Err(), "error occurred when flushing the audit records")
+	}
+}
+
+// startWriting does not run a new goroutine and should be called in a new one.
+func (l *Logger) startWriting(ctx context.Context) {
+	defer close(l.done)
+
+	ticker := time.NewTicker(l.cfg.FlushInterval)
+	defer ticker.Stop()
+
+	batch := make([]Record, 0, l.cfg.BatchSize)
+
+	for {
+		select {
+		case <-ctx.Done():
+			l.drain(context.WithoutCancel(ctx), batch)
+
+			return
+		case <-l.stop:
+			l.drain(context.WithoutCancel(ctx), batch)
+
+			return
+		case record := <-l.records:
+			batch = append(batch, record)
+			if len(batch) == l.cfg.BatchSize {
+				batch = l.flush(ctx, batch)
+			}
+		case <-ticker.C:
+			batch = l.flush(ctx, batch)
+		}
+	}
+}
+
+// drain flushes the batch and the buffered records.
+func (l *Logger) drain(ctx context.Context, batch []Record) {
+	for {
+		select {
+		case record := <-l.records:
+			batch = append(batch, record)
+			if len(batch) == l.cfg.BatchSize {
+				batch = l.flush(ctx, batch)
+			}
+		default:
+			l.flush(ctx, batch)
+
+			return
+		}
+	}
+}
+
+// flush writes the batch and returns it emptied, the records of a failed write are lost.
+func (l *Logger) flush(ctx context.Context, batch []Record) []Record {
+	if len(batch) == 0 {
+		return batch
+	}
+
+	if err := l.sink.Write(ctx, batch); err != nil {
+		logger.Errorf("error occurred when writing %d audit records: %v", len(batch), err)
+		l.metrics.written.WithLabelValues("failed").Add(float64(len(batch)))
+	} else {
+		l.metrics.written.WithLabelValues("written").Add(float64(len(batch)))
+	}
+
+	return batch[:0]
+}
@@ -0,0 +1,111 @@
+package audit
+
+import (
+	"context"
+
+	"platform/logger"
+	"platform/msg_queue"
+	"platform/postgres_pool"
+
+	"github.com/caarlos0/env/v11"
+	"github.com/goccy/go-json"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/pkg/errors"
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+type sinkConfig struct {
+	// Sink is "postgres" or "kafka".
+	Sink  string `env:"AUDIT_SINK" envDefault:"postgres"`
+	Topic string `env:"AUDIT_TOPIC" envDefault:"audit"`
+}
+
+// MustCreateSink creates the sink of AUDIT_SINK: the audit_log table of the main postgres pool,
+// migrated first, or AUDIT_TOPIC of the main publisher.
+func MustCreateSink(ctx context.Context) Sink {
+	cfg, err := env.ParseAs[sinkConfig]()
+	if err != nil {
+		logger.Fatal(err.Error())
+
+		return nil
+	}
+
+	switch cfg.Sink {
+	case "postgres":
+		pool := postgres_pool.MustCreatePostgresPool()
+		postgres_pool.MustMigrate(ctx, pool)
+
+		return PostgresSink(pool)
+	case "kafka":
+		return KafkaSink(msg_queue.MustCreateMainPublisher(), cfg.Topic)
+	default:
+		logger.Fatalf("unknown audit sink: %s", cfg.Sink)
+
+		return nil
+	}
+}
+
+type postgresSink struct {
+	pool *pgxpool.Pool
+}
+
+// PostgresSink copies the records to the audit_log table, which is created by the migrations
+// (see postgres_pool.MustMigrate).
+func PostgresSink(pool *pgxpool.Pool) Sink {
+	return &postgresSink{pool: pool}
+}
+
+func (s *postgresSink) Write(ctx context.Context, records []Record) error {
+	_, err := s.pool.CopyFrom(
+		ctx,
+		pgx.Identifier{"audit_log"},
+		[]string{"time", "actor", "operation", "request_id", "payload", "code", "reason"},
+		pgx.CopyFromSlice(len(records), func(i int) ([]any, error) {
+			record := records[i]
+
+			var payload any
+			if len(record.Payload) > 0 {
+				payload = string(record.Payload)
+			}
+
+			return []any{
+				record.Time, record.Actor, record.Operation, record.RequestID, payload, record.Code, record.Reason,
+			}, nil
+		}),
+	)
+	if err != nil {
+		return errors.Wrap(err, "error occurred when copying audit records")
+	}
+
+	return nil
+}
+
+type kafkaSink struct {
+	publisher msg_queue.Publisher
+	topic     string
+}
+
+// KafkaSink produces every record as JSON to the topic, keyed by the actor.
+func KafkaSink(publisher msg_queue.Publisher, topic string) Sink {
+	return &kafkaSink{publisher: publisher, topic: topic}
+}
+
+func (s *kafkaSink) Write(ctx context.Context, records []Record) error {
+	for _, record := range records {
+		value, err := json.Marshal(record)
+		if err != nil {
+			return errors.Wrap(err, "error occurred when marshalling an audit record")
+		}
+
+		if err = s.publisher.ProduceRecord(ctx, &kgo.Record{
+			Topic: s.topic,
+			Key:   []byte(record.Actor),
+			Value: value,
+		}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
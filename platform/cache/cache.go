@@ -2,6 +2,7 @@ package cache
 
 import (
 	"context"
+	"time"
 )
 
 type Cache interface {
@@ -13,6 +14,9 @@ type Cache interface {
 	SetNegativeCase(ctx context.Context, table string, key string)
 	SetString(ctx context.Context, table string, key string, value string)
 	SetBytes(ctx context.Context, table string, key string, value []byte)
+	// SetBytesWithTTL and SetBytesIfNotExists return errors, so they can be used for coordination.
+	SetBytesWithTTL(ctx context.Context, table string, key string, value []byte, ttl time.Duration) error
+	SetBytesIfNotExists(ctx context.Context, table string, key string, value []byte, ttl time.Duration) (bool, error)
 
 	Delete(ctx context.Context, table string, key string) error
 }
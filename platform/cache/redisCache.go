@@ -6,6 +6,7 @@ import (
 	"time"
 
 	"platform/logger"
+	"platform/tenant"
 
 	fb "github.com/Eugene-Usachev/fastbytes"
 	"github.com/caarlos0/env/v11"
@@ -14,6 +15,8 @@ import (
 	"github.com/redis/rueidis"
 )
 
+const tenantKeyPrefix = "tenant:"
+
 type RedisCache struct {
 	client      rueidis.Client
 	cacheHits   *prometheus.CounterVec
@@ -30,7 +33,8 @@ type redisConfig struct {
 	Password string `env:"REDIS_PASSWORD, required, notEmpty"`
 }
 
-func MustCreateRedisCache() *RedisCache {
+// MustCreateRedisClient creates a client for the redis configured by REDIS_ADDRS (a JSON array) and REDIS_PASSWORD.
+func MustCreateRedisClient() rueidis.Client {
 	cfg, err := env.ParseAs[redisConfig]()
 	if err != nil {
 		logger.Fatal(err.Error())
@@ -62,6 +66,12 @@ func MustCreateRedisCache() *RedisCache {
 		logger.Fatalf("error occurred when creating a redis client: %s", err.Error())
 	}
 
+	return client
+}
+
+func MustCreateRedisCache() *RedisCache {
+	client := MustCreateRedisClient()
+
 	cacheHits := prometheus.NewCounterVec(
 		prometheus.CounterOpts{
 			Name: "cache_hits_total",
@@ -88,10 +98,20 @@ func MustCreateRedisCache() *RedisCache {
 
 var _ Cache = (*RedisCache)(nil)
 
-func redisKey(table string, key string) string {
+// redisKey is "table:key", prefixed with "tenant:<id>:" for a request of a tenant, so the stores do not share entries.
+func redisKey(ctx context.Context, table string, key string) string {
 	builder := strings.Builder{}
 
-	builder.Grow(len(table) + len(key) + 1)
+	id := tenant.FromContext(ctx)
+
+	builder.Grow(len(tenantKeyPrefix) + len(id) + len(table) + len(key) + 2)
+
+	if id != "" {
+		builder.WriteString(tenantKeyPrefix)
+		builder.WriteString(id)
+		builder.WriteByte(':')
+	}
+
 	builder.WriteString(table)
 	builder.WriteByte(':')
 	builder.WriteString(key)
@@ -100,7 +120,7 @@ func redisKey(table string, key string) string {
 }
 
 func (cache *RedisCache) IsNegativeCase(ctx context.Context, table string, key string) bool {
-	realKey := redisKey(table, key)
+	realKey := redisKey(ctx, table, key)
 
 	res, err := cache.client.Do(ctx, cache.client.B().Exists().Key(realKey).Build()).AsBool()
 	if err != nil {
@@ -126,7 +146,7 @@ func (cache *RedisCache) GetString(
 	table string,
 	key string,
 ) (string, bool) {
-	realKey := redisKey(table, key)
+	realKey := redisKey(ctx, table, key)
 
 	res, err := cache.client.Do(ctx, cache.client.B().Get().Key(realKey).Build()).ToString()
 	if err != nil {
@@ -153,7 +173,7 @@ func (cache *RedisCache) GetBytes(
 	table string,
 	key string,
 ) ([]byte, bool) {
-	realKey := redisKey(table, key)
+	realKey := redisKey(ctx, table, key)
 
 	res, err := cache.client.Do(ctx, cache.client.B().Get().Key(realKey).Build()).AsBytes()
 	if err != nil {
@@ -176,7 +196,7 @@ func (cache *RedisCache) GetBytes(
 }
 
 func (cache *RedisCache) SetString(ctx context.Context, table string, key string, value string) {
-	realKey := redisKey(table, key)
+	realKey := redisKey(ctx, table, key)
 
 	if err := cache.client.Do(
 		ctx,
@@ -191,7 +211,7 @@ func (cache *RedisCache) SetString(ctx context.Context, table string, key string
 }
 
 func (cache *RedisCache) SetBytes(ctx context.Context, table string, key string, value []byte) {
-	realKey := redisKey(table, key)
+	realKey := redisKey(ctx, table, key)
 
 	if err := cache.client.Do(
 		ctx,
@@ -204,8 +224,47 @@ func (cache *RedisCache) SetBytes(ctx context.Context, table string, key string,
 	}
 }
 
+func (cache *RedisCache) SetBytesWithTTL(
+	ctx context.Context,
+	table string,
+	key string,
+	value []byte,
+	ttl time.Duration,
+) error {
+	realKey := redisKey(ctx, table, key)
+
+	return cache.client.Do(
+		ctx,
+		cache.client.B().Set().Key(realKey).Value(fb.B2S(value)).Px(ttl).Build(),
+	).Error()
+}
+
+func (cache *RedisCache) SetBytesIfNotExists(
+	ctx context.Context,
+	table string,
+	key string,
+	value []byte,
+	ttl time.Duration,
+) (bool, error) {
+	realKey := redisKey(ctx, table, key)
+
+	err := cache.client.Do(
+		ctx,
+		cache.client.B().Set().Key(realKey).Value(fb.B2S(value)).Nx().Px(ttl).Build(),
+	).Error()
+	if rueidis.IsRedisNil(err) {
+		return false, nil
+	}
+
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
 func (cache *RedisCache) SetNegativeCase(ctx context.Context, table string, key string) {
-	realKey := redisKey(table, key)
+	realKey := redisKey(ctx, table, key)
 
 	if err := cache.client.Do(
 		ctx,
@@ -219,7 +278,7 @@ func (cache *RedisCache) SetNegativeCase(ctx context.Context, table string, key
 }
 
 func (cache *RedisCache) Delete(ctx context.Context, table string, key string) error {
-	realKey := redisKey(table, key)
+	realKey := redisKey(ctx, table, key)
 
 	return cache.client.Do(ctx, cache.client.B().Del().Key(realKey).Build()).Error()
 }
@@ -1,6 +1,11 @@
 package logger
 
 import (
+	"context"
+
+	"platform/requestid"
+	"platform/tenant"
+
 	"github.com/go-kratos/kratos/v2/log"
 	"github.com/go-kratos/kratos/v2/middleware/tracing"
 )
@@ -17,6 +22,8 @@ func InitMainLogger() Logger {
 			"caller", log.DefaultCaller,
 			"trace.id", tracing.TraceID(),
 			"span.id", tracing.SpanID(),
+			"request.id", requestid.Valuer(),
+			"tenant.id", tenant.Valuer(),
 		)),
 	}
 
@@ -31,6 +38,11 @@ func MainLogger() *Logger {
 	return &mainLoggerInstance
 }
 
+// WithContext returns the main logger with the values of ctx, such as the request ID, in every line.
+func WithContext(ctx context.Context) *Logger {
+	return &Logger{*log.NewHelper(log.WithContext(ctx, MainLogger().Logger()))}
+}
+
 func (l *Logger) Print(msg string) {
 	l.Info(msg)
 }
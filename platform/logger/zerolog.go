@@ -3,11 +3,33 @@ package logger
 import (
 	"fmt"
 	"os"
+	"slices"
 
 	"github.com/go-kratos/kratos/v2/log"
+	"github.com/pkg/errors"
 	"github.com/rs/zerolog"
 )
 
+// levels are the levels of SetLevel, fatal is excluded since zerolog does not exit on a disabled fatal.
+var levels = []zerolog.Level{zerolog.DebugLevel, zerolog.InfoLevel, zerolog.WarnLevel, zerolog.ErrorLevel}
+
+// Level returns the minimum level of the logged lines.
+func Level() string {
+	return zerolog.GlobalLevel().String()
+}
+
+// SetLevel changes the minimum level of the logged lines at runtime: debug, info, warn or error.
+func SetLevel(level string) error {
+	l, err := zerolog.ParseLevel(level)
+	if err != nil || !slices.Contains(levels, l) {
+		return errors.Errorf("unknown log level: %s", level)
+	}
+
+	zerolog.SetGlobalLevel(l)
+
+	return nil
+}
+
 type ZeroLogger struct {
 	inner zerolog.Logger
 }
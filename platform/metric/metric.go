@@ -1,22 +1,35 @@
 package metric
 
 import (
+	"runtime"
+	"runtime/debug"
+	"sync"
+	"sync/atomic"
+
 	"platform/logger"
 
 	"github.com/caarlos0/env/v11"
 	"github.com/go-kratos/kratos/v2/middleware/metrics"
 	"github.com/go-kratos/kratos/v2/transport/http"
+	"github.com/pkg/errors"
+	promclient "github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/exporters/prometheus"
 	"go.opentelemetry.io/otel/metric"
 	"go.opentelemetry.io/otel/metric/noop"
 	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/resource"
+	semconv "go.opentelemetry.io/otel/semconv/v1.37.0"
 )
 
 type config struct {
 	Enabled              bool   `env:"METRICS_ENABLED" envDefault:"true"`
 	RequestsCounterName  string `env:"METRICS_REQUESTS_COUNTER_NAME" envDefault:"server_requests_total"`
 	SecondsHistogramName string `env:"METRICS_SECONDS_HISTOGRAM_NAME" envDefault:"server_request_duration_seconds"`
+	// SecondsBuckets are the bucket boundaries of every histogram in seconds.
+	SecondsBuckets []float64 `env:"METRICS_SECONDS_BUCKETS" envDefault:"0.005,0.01,0.025,0.05,0.1,0.25,0.5,1,2.5,5"`
 }
 
 func mustLoadConfig() config {
@@ -27,23 +40,129 @@ func mustLoadConfig() config {
 	return cfg
 }
 
-func NewMetric(serviceName string) (metric.Int64Counter, metric.Float64Histogram) {
+var (
+	providerOnce  sync.Once
+	provider      metric.MeterProvider
+	isInitialized atomic.Bool
+)
+
+// MustInitMeterProvider creates the process-wide meter provider and sets it as the global one. It replaces
+// the default Prometheus registry with one of the Go runtime, process and build info metrics of the service,
+// which the provider exports to. The platform packages register their collectors on the default registry
+// directly, it is the contract of /metrics, and their histograms in seconds use SecondsBuckets.
+// main must call it once the config is loaded, before any metric is registered, the later calls do nothing.
+func MustInitMeterProvider(name string, version string) {
+	providerOnce.Do(func() {
+		provider = mustCreateMeterProvider(name, version)
+		otel.SetMeterProvider(provider)
+		isInitialized.Store(true)
+	})
+}
+
+// Meter returns the meter of the process-wide provider, a noop one if METRICS_ENABLED is false.
+// It fails if MustInitMeterProvider has not been called, the metrics would not be labeled by the service.
+func Meter(name string) metric.Meter {
+	if !isInitialized.Load() {
+		logger.Fatalf("meter %s is requested before metric.MustInitMeterProvider", name)
+
+		return noop.NewMeterProvider().Meter(name)
+	}
+
+	return provider.Meter(name)
+}
+
+func mustCreateMeterProvider(name string, version string) metric.MeterProvider {
 	cfg := mustLoadConfig()
 
 	if !cfg.Enabled {
-		return noop.Int64Counter{}, noop.Float64Histogram{}
+		return noop.NewMeterProvider()
 	}
 
-	exporter, err := prometheus.New()
+	registry := newRegistry(name, version)
+	promclient.DefaultRegisterer = registry
+	promclient.DefaultGatherer = registry
+
+	exporter, err := prometheus.New(prometheus.WithRegisterer(registry))
 	if err != nil {
-		logger.Fatal(err.Error())
+		logger.Fatal(errors.Wrap(err, "error occurred when creating a prometheus exporter").Error())
+
+		return noop.NewMeterProvider()
 	}
 
-	provider := sdkmetric.NewMeterProvider(
+	res, err := resource.Merge(resource.Default(), resource.NewWithAttributes(
+		semconv.SchemaURL,
+		semconv.ServiceName(name),
+		semconv.ServiceVersion(version),
+	))
+	if err != nil {
+		logger.Fatal(errors.Wrap(err, "error occurred when creating a metrics resource").Error())
+
+		return noop.NewMeterProvider()
+	}
+
+	return sdkmetric.NewMeterProvider(
+		sdkmetric.WithResource(res),
 		sdkmetric.WithReader(exporter),
+		sdkmetric.WithView(sdkmetric.NewView(
+			sdkmetric.Instrument{Kind: sdkmetric.InstrumentKindHistogram, Unit: "s"},
+			sdkmetric.Stream{Aggregation: sdkmetric.AggregationExplicitBucketHistogram{Boundaries: cfg.SecondsBuckets}},
+		)),
 	)
+}
+
+// newRegistry returns a registry of the Go collector, with the GC and scheduler runtime metrics,
+// the process collector and service_build_info.
+func newRegistry(name string, version string) *promclient.Registry {
+	buildInfo := promclient.NewGaugeVec(
+		promclient.GaugeOpts{
+			Name: "service_build_info",
+			Help: "Always 1, labeled by the service, its version, VCS revision and Go version",
+		},
+		[]string{"service", "version", "revision", "go_version"},
+	)
+
+	registry := promclient.NewRegistry()
+	registry.MustRegister(
+		collectors.NewGoCollector(collectors.WithGoCollectorRuntimeMetrics(
+			collectors.MetricsGC,
+			collectors.MetricsScheduler,
+		)),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		buildInfo,
+	)
+
+	buildInfo.WithLabelValues(name, version, revision(), runtime.Version()).Set(1)
+
+	return registry
+}
+
+func revision() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return ""
+	}
+
+	for _, setting := range info.Settings {
+		if setting.Key == "vcs.revision" {
+			return setting.Value
+		}
+	}
+
+	return ""
+}
+
+// SecondsBuckets returns the METRICS_SECONDS_BUCKETS boundaries for the Prometheus histograms in seconds,
+// the provider applies them to the instruments of unit "s".
+func SecondsBuckets() []float64 {
+	return mustLoadConfig().SecondsBuckets
+}
+
+// NewMetric returns the kratos requests counter and seconds histogram of the service, the instruments
+// are shared by the calls with the same serviceName, e.g. for the HTTP and gRPC servers.
+func NewMetric(serviceName string) (metric.Int64Counter, metric.Float64Histogram) {
+	cfg := mustLoadConfig()
 
-	meter := provider.Meter(serviceName)
+	meter := Meter(serviceName)
 
 	requestsCounter, err := metrics.DefaultRequestsCounter(
 		meter,
@@ -0,0 +1,170 @@
+package middleware
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"platform/audit"
+	"platform/requestid"
+
+	kratoserrors "github.com/go-kratos/kratos/v2/errors"
+	"github.com/go-kratos/kratos/v2/middleware"
+	"github.com/go-kratos/kratos/v2/transport"
+	khttp "github.com/go-kratos/kratos/v2/transport/http"
+	"github.com/goccy/go-json"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+const redacted = "REDACTED"
+
+// sensitiveFields are redacted from the audited payloads in addition to the fields with the debug_redact option.
+var sensitiveFields = []string{"password", "secret", "token", "card_number", "cvv", "cvc", "iban"}
+
+type auditActorKey struct{}
+
+// Audit logs the actor, operation, request ID, sanitized payload and result of every mutating operation:
+// a non-safe HTTP method or, for gRPC, a method not starting with Get, List, Search or Watch.
+// It must precede Auth and the other middlewares that reject requests, such as the rate limiters and
+// the validation, so the denials are logged with their code. Auth reports the actor, the requests without
+// claims are logged with an empty one. The replays of Idempotency are not logged again.
+func Audit(l *audit.Logger) middleware.Middleware {
+	return func(handler middleware.Handler) middleware.Handler {
+		return func(ctx context.Context, req any) (any, error) {
+			tr, ok := transport.FromServerContext(ctx)
+			if !ok || isReadOperation(ctx, tr) {
+				return handler(ctx, req)
+			}
+
+			record := audit.Record{
+				Time:      time.Now().UTC(),
+				Operation: tr.Operation(),
+				RequestID: requestid.FromContext(ctx),
+				Payload:   sanitizedPayload(req),
+			}
+
+			actor := new(string)
+			if claims, ok := ClaimsFromContext(ctx); ok {
+				*actor = claims.Subject
+			}
+
+			reply, err := handler(context.WithValue(ctx, auditActorKey{}, actor), req)
+
+			if tr.ReplyHeader().Get(headerIdempotentReplayed) != "" {
+				return reply, err
+			}
+
+			record.Actor = *actor
+			record.Code = 200
+			if err != nil {
+				kratosErr := kratoserrors.FromError(err)
+				record.Code, record.Reason = kratosErr.Code, kratosErr.Reason
+			}
+
+			l.Log(record)
+
+			return reply, err
+		}
+	}
+}
+
+// setAuditActor reports the subject of the verified claims to the Audit that precedes Auth.
+func setAuditActor(ctx context.Context, subject string) {
+	if actor, ok := ctx.Value(auditActorKey{}).(*string); ok {
+		*actor = subject
+	}
+}
+
+func isReadOperation(ctx context.Context, tr transport.Transporter) bool {
+	if _, ok := khttp.RequestFromServerContext(ctx); ok {
+		return isSafeHTTPMethod(ctx)
+	}
+
+	operation := tr.Operation()
+	method := operation[strings.LastIndex(operation, "/")+1:]
+
+	for _, prefix := range []string{"Get", "List", "Search", "Watch"} {
+		if strings.HasPrefix(method, prefix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// sanitizedPayload returns the request in JSON with its sensitive fields redacted.
+func sanitizedPayload(req any) json.RawMessage {
+	msg, ok := req.(proto.Message)
+	if !ok {
+		data, err := json.Marshal(req)
+		if err != nil {
+			return nil
+		}
+
+		return data
+	}
+
+	msg = proto.Clone(msg)
+	redact(msg.ProtoReflect())
+
+	data, err := protojson.Marshal(msg)
+	if err != nil {
+		return nil
+	}
+
+	return data
+}
+
+func redact(msg protoreflect.Message) {
+	msg.Range(func(field protoreflect.FieldDescriptor, value protoreflect.Value) bool {
+		if isSensitiveField(field) {
+			if field.Kind() == protoreflect.StringKind && !field.IsList() && !field.IsMap() {
+				msg.Set(field, protoreflect.ValueOfString(redacted))
+			} else {
+				msg.Clear(field)
+			}
+
+			return true
+		}
+
+		switch {
+		case field.IsMap():
+			if field.MapValue().Kind() == protoreflect.MessageKind {
+				value.Map().Range(func(_ protoreflect.MapKey, v protoreflect.Value) bool {
+					redact(v.Message())
+
+					return true
+				})
+			}
+		case field.IsList():
+			if field.Kind() == protoreflect.MessageKind {
+				list := value.List()
+				for i := range list.Len() {
+					redact(list.Get(i).Message())
+				}
+			}
+		case field.Kind() == protoreflect.MessageKind:
+			redact(value.Message())
+		}
+
+		return true
+	})
+}
+
+func isSensitiveField(field protoreflect.FieldDescriptor) bool {
+	if options, ok := field.Options().(*descriptorpb.FieldOptions); ok && options.GetDebugRedact() {
+		return true
+	}
+
+	name := strings.ToLower(string(field.Name()))
+	for _, sensitive := range sensitiveFields {
+		if strings.Contains(name, sensitive) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,94 @@
+package middleware
+
+import (
+	"context"
+	"maps"
+	"net/http"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"platform/audit"
+
+	"github.com/go-kratos/kratos/v2/middleware"
+	"github.com/go-kratos/kratos/v2/transport"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+type recordingSink struct {
+	mu      sync.Mutex
+	records []audit.Record
+}
+
+func (s *recordingSink) Write(_ context.Context, records []audit.Record) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.records = append(s.records, records...)
+
+	return nil
+}
+
+type testHeader http.Header
+
+func (h testHeader) Get(key string) string      { return http.Header(h).Get(key) }
+func (h testHeader) Set(key, value string)      { http.Header(h).Set(key, value) }
+func (h testHeader) Add(key, value string)      { http.Header(h).Add(key, value) }
+func (h testHeader) Values(key string) []string { return http.Header(h).Values(key) }
+
+func (h testHeader) Keys() []string {
+	return slices.Collect(maps.Keys(h))
+}
+
+// testTransport is a gRPC server transport.
+type testTransport struct {
+	operation string
+	request   testHeader
+	reply     testHeader
+}
+
+func (tr *testTransport) Kind() transport.Kind            { return transport.KindGRPC }
+func (tr *testTransport) Endpoint() string                { return "" }
+func (tr *testTransport) Operation() string               { return tr.operation }
+func (tr *testTransport) RequestHeader() transport.Header { return tr.request }
+func (tr *testTransport) ReplyHeader() transport.Header   { return tr.reply }
+
+func TestAuditLogsDenials(t *testing.T) {
+	sink := &recordingSink{}
+	l := audit.MustCreateLogger(context.Background(), sink)
+
+	deny := func(handler middleware.Handler) middleware.Handler {
+		return func(ctx context.Context, req any) (any, error) {
+			setAuditActor(ctx, "user-1")
+
+			return nil, ErrForbidden
+		}
+	}
+
+	handler := middleware.Chain(Audit(l), deny)(func(context.Context, any) (any, error) {
+		t.Fatal("the denied request reached the handler")
+
+		return nil, nil
+	})
+
+	ctx := transport.NewServerContext(context.Background(), &testTransport{
+		operation: "/orders.v1.OrderService/CancelOrder",
+		reply:     testHeader{},
+	})
+
+	_, err := handler(ctx, wrapperspb.String("order-1"))
+	require.ErrorIs(t, err, ErrForbidden)
+
+	closeCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	require.NoError(t, l.Close(closeCtx))
+
+	require.Len(t, sink.records, 1)
+	assert.Equal(t, "user-1", sink.records[0].Actor)
+	assert.Equal(t, int32(403), sink.records[0].Code)
+	assert.Equal(t, ErrForbidden.Reason, sink.records[0].Reason)
+}
@@ -0,0 +1,228 @@
+package middleware
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"time"
+
+	"platform/logger"
+
+	"github.com/caarlos0/env/v11"
+	kratoserrors "github.com/go-kratos/kratos/v2/errors"
+	"github.com/go-kratos/kratos/v2/middleware"
+	"github.com/go-kratos/kratos/v2/transport"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/pkg/errors"
+)
+
+var (
+	ErrUnauthorized = kratoserrors.Unauthorized("UNAUTHORIZED", "missing or invalid token")
+	ErrForbidden    = kratoserrors.Forbidden("FORBIDDEN", "not enough permissions")
+)
+
+type AuthAccess int
+
+const (
+	// AccessAuthenticated requires a valid token, it is the policy of operations without one.
+	AccessAuthenticated AuthAccess = iota
+	AccessPublic
+	// AccessRoles requires a valid token with at least one of the roles of the policy.
+	AccessRoles
+)
+
+type AuthPolicy struct {
+	Access AuthAccess
+	Roles  []string
+}
+
+// Claims are the claims of a validated token, see ClaimsFromContext.
+type Claims struct {
+	jwt.RegisteredClaims
+
+	Roles []string `json:"roles"`
+	// Tenant is the store the token is issued for, see Tenant.
+	Tenant string `json:"tenant_id,omitempty"`
+}
+
+type claimsKey struct{}
+
+// ClaimsFromContext returns the claims put by Auth, there are none for public operations without a token.
+func ClaimsFromContext(ctx context.Context) (*Claims, bool) {
+	claims, ok := ctx.Value(claimsKey{}).(*Claims)
+
+	return claims, ok
+}
+
+type AuthConfig struct {
+	// HMACSecret verifies HS256 tokens, JWKSFile or JWKSURL verify RS256 and EdDSA tokens.
+	HMACSecret string
+	JWKSFile   string
+	JWKSURL    string
+	// JWKSRefreshInterval is how often the JWKS is reloaded, zero disables reloading.
+	JWKSRefreshInterval time.Duration
+	// Issuer and Audience are checked if they are not empty.
+	Issuer   string
+	Audience string
+	// Policies are the policies by kratos operation, the other operations require AccessAuthenticated.
+	Policies map[string]AuthPolicy
+}
+
+type authConfig struct {
+	HMACSecret          string        `env:"AUTH_HMAC_SECRET"`
+	JWKSFile            string        `env:"AUTH_JWKS_FILE"`
+	JWKSURL             string        `env:"AUTH_JWKS_URL"`
+	JWKSRefreshInterval time.Duration `env:"AUTH_JWKS_REFRESH_INTERVAL" envDefault:"5m"`
+	Issuer              string        `env:"AUTH_ISSUER"`
+	Audience            string        `env:"AUTH_AUDIENCE"`
+}
+
+// LoadAuthConfig reads AuthConfig without the policies from AUTH_HMAC_SECRET, AUTH_JWKS_FILE, AUTH_JWKS_URL,
+// AUTH_JWKS_REFRESH_INTERVAL, AUTH_ISSUER and AUTH_AUDIENCE.
+func LoadAuthConfig() (AuthConfig, error) {
+	cfg, err := env.ParseAs[authConfig]()
+	if err != nil {
+		return AuthConfig{}, err
+	}
+
+	return AuthConfig{
+		HMACSecret:          cfg.HMACSecret,
+		JWKSFile:            cfg.JWKSFile,
+		JWKSURL:             cfg.JWKSURL,
+		JWKSRefreshInterval: cfg.JWKSRefreshInterval,
+		Issuer:              cfg.Issuer,
+		Audience:            cfg.Audience,
+	}, nil
+}
+
+func MustCreateAuth(ctx context.Context, policies map[string]AuthPolicy) middleware.Middleware {
+	cfg, err := LoadAuthConfig()
+	if err != nil {
+		logger.Fatal(err.Error())
+
+		return nil
+	}
+
+	cfg.Policies = policies
+
+	m, err := Auth(ctx, cfg)
+	if err != nil {
+		logger.Fatal(err.Error())
+
+		return nil
+	}
+
+	return m
+}
+
+// Auth validates the bearer token of the Authorization header by the policy of the operation
+// and puts its claims into the context (see ClaimsFromContext) and its subject as the user
+// of RateLimitByUser. It works with any kratos transport, including gRPC.
+func Auth(ctx context.Context, cfg AuthConfig) (middleware.Middleware, error) {
+	if cfg.JWKSFile != "" && cfg.JWKSURL != "" {
+		return nil, errors.New("only one of JWKS file and JWKS URL can be set")
+	}
+
+	var (
+		keys    *jwks
+		methods []string
+	)
+
+	if cfg.HMACSecret != "" {
+		methods = append(methods, jwt.SigningMethodHS256.Alg())
+	}
+
+	if cfg.JWKSFile != "" || cfg.JWKSURL != "" {
+		var err error
+
+		keys, err = newJWKS(ctx, cfg.JWKSFile, cfg.JWKSURL, cfg.JWKSRefreshInterval)
+		if err != nil {
+			return nil, err
+		}
+
+		methods = append(methods, jwt.SigningMethodRS256.Alg(), jwt.SigningMethodEdDSA.Alg())
+	}
+
+	// Nothing can be verified without keys, so only the public operations without a token are served.
+	canVerify := len(methods) > 0
+	if !canVerify {
+		logger.Info("neither HMAC secret nor JWKS is set for auth, only public operations are allowed")
+	}
+
+	parserOpts := []jwt.ParserOption{
+		jwt.WithValidMethods(methods),
+		jwt.WithExpirationRequired(),
+		jwt.WithLeeway(time.Minute),
+	}
+
+	if cfg.Issuer != "" {
+		parserOpts = append(parserOpts, jwt.WithIssuer(cfg.Issuer))
+	}
+
+	if cfg.Audience != "" {
+		parserOpts = append(parserOpts, jwt.WithAudience(cfg.Audience))
+	}
+
+	parser := jwt.NewParser(parserOpts...)
+
+	keyFunc := func(ctx context.Context) jwt.Keyfunc {
+		return func(token *jwt.Token) (any, error) {
+			if token.Method == jwt.SigningMethodHS256 {
+				return []byte(cfg.HMACSecret), nil
+			}
+
+			kid, _ := token.Header["kid"].(string)
+
+			key, ok := keys.key(ctx, kid)
+			if !ok {
+				return nil, errors.Errorf("unknown key id %q", kid)
+			}
+
+			return key, nil
+		}
+	}
+
+	return func(handler middleware.Handler) middleware.Handler {
+		return func(ctx context.Context, req any) (any, error) {
+			tr, ok := transport.FromServerContext(ctx)
+			if !ok {
+				return nil, ErrUnauthorized
+			}
+
+			policy := cfg.Policies[tr.Operation()]
+
+			raw, hasToken := strings.CutPrefix(tr.RequestHeader().Get("Authorization"), "Bearer ")
+			if !hasToken || raw == "" {
+				if policy.Access == AccessPublic {
+					return handler(ctx, req)
+				}
+
+				return nil, ErrUnauthorized
+			}
+
+			if !canVerify {
+				return nil, ErrUnauthorized
+			}
+
+			claims := &Claims{}
+
+			if _, err := parser.ParseWithClaims(raw, claims, keyFunc(ctx)); err != nil {
+				// An invalid token is rejected even on public operations, so a client notices it.
+				return nil, ErrUnauthorized.WithCause(err)
+			}
+
+			setAuditActor(ctx, claims.Subject)
+
+			if policy.Access == AccessRoles && !slices.ContainsFunc(claims.Roles, func(role string) bool {
+				return slices.Contains(policy.Roles, role)
+			}) {
+				return nil, ErrForbidden
+			}
+
+			ctx = context.WithValue(ctx, claimsKey{}, claims)
+			ctx = ContextWithUser(ctx, claims.Subject)
+
+			return handler(ctx, req)
+		}
+	}, nil
+}
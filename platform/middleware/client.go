@@ -0,0 +1,100 @@
+package middleware
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"platform/logger"
+
+	"github.com/caarlos0/env/v11"
+	"github.com/go-kratos/kratos/v2/middleware"
+	"github.com/go-kratos/kratos/v2/middleware/tracing"
+	"github.com/go-kratos/kratos/v2/transport"
+)
+
+// headerRequestTimeout carries the remaining time of the caller in milliseconds, see Deadline.
+const headerRequestTimeout = "X-Request-Timeout"
+
+type clientConfig struct {
+	Timeout              time.Duration `env:"CLIENT_TIMEOUT" envDefault:"2s"`
+	RetryMaxAttempts     int           `env:"CLIENT_RETRY_MAX_ATTEMPTS" envDefault:"3"`
+	RetryBaseBackoff     time.Duration `env:"CLIENT_RETRY_BASE_BACKOFF" envDefault:"50ms"`
+	RetryMaxBackoff      time.Duration `env:"CLIENT_RETRY_MAX_BACKOFF" envDefault:"1s"`
+	IdempotentOperations []string      `env:"CLIENT_RETRY_IDEMPOTENT_OPERATIONS"`
+}
+
+// MustCreateClientMiddlewares returns the middlewares of the kratos HTTP and gRPC clients:
+// tracing, request ID, tenant, Deadline of CLIENT_TIMEOUT, Retry of CLIENT_RETRY_* and CircuitBreaker, e.g.
+//
+//	http.NewClient(ctx, http.WithEndpoint(endpoint), http.WithMiddleware(middleware.MustCreateClientMiddlewares()...))
+func MustCreateClientMiddlewares() []middleware.Middleware {
+	cfg, err := env.ParseAs[clientConfig]()
+	if err != nil {
+		logger.Fatal(err.Error())
+
+		return nil
+	}
+
+	retry, err := Retry(RetryConfig{
+		MaxAttempts:          cfg.RetryMaxAttempts,
+		BaseBackoff:          cfg.RetryBaseBackoff,
+		MaxBackoff:           cfg.RetryMaxBackoff,
+		IdempotentOperations: cfg.IdempotentOperations,
+	})
+	if err != nil {
+		logger.Fatal(err.Error())
+
+		return nil
+	}
+
+	return []middleware.Middleware{
+		tracing.Client(),
+		RequestIDClient(),
+		TenantClient(),
+		Deadline(cfg.Timeout),
+		retry,
+		CircuitBreaker(),
+	}
+}
+
+// Deadline bounds every call, with all its retries, by timeout or by the deadline of the caller if it is sooner,
+// and sends the remaining time in the X-Request-Timeout header, gRPC sends it by itself. See DeadlineServer.
+func Deadline(timeout time.Duration) middleware.Middleware {
+	return func(handler middleware.Handler) middleware.Handler {
+		return func(ctx context.Context, req any) (any, error) {
+			ctx, cancel := context.WithTimeout(ctx, timeout)
+			defer cancel()
+
+			if tr, ok := transport.FromClientContext(ctx); ok && tr.Kind() == transport.KindHTTP {
+				deadline, _ := ctx.Deadline()
+				tr.RequestHeader().Set(headerRequestTimeout, strconv.FormatInt(time.Until(deadline).Milliseconds(), 10))
+			}
+
+			return handler(ctx, req)
+		}
+	}
+}
+
+// DeadlineServer applies the X-Request-Timeout of the caller to the request context, so a server
+// does not work on the requests the caller has given up on. It can only shorten the deadline.
+func DeadlineServer() middleware.Middleware {
+	return func(handler middleware.Handler) middleware.Handler {
+		return func(ctx context.Context, req any) (any, error) {
+			tr, ok := transport.FromServerContext(ctx)
+			if !ok {
+				return handler(ctx, req)
+			}
+
+			timeout, err := strconv.ParseInt(tr.RequestHeader().Get(headerRequestTimeout), 10, 64)
+			if err != nil || timeout <= 0 {
+				return handler(ctx, req)
+			}
+
+			ctx, cancel := context.WithTimeout(ctx, time.Duration(timeout)*time.Millisecond)
+			defer cancel()
+
+			return handler(ctx, req)
+		}
+	}
+}
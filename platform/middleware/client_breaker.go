@@ -0,0 +1,61 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"sync"
+
+	"github.com/go-kratos/aegis/circuitbreaker"
+	"github.com/go-kratos/aegis/circuitbreaker/sre"
+	kratoserrors "github.com/go-kratos/kratos/v2/errors"
+	"github.com/go-kratos/kratos/v2/middleware"
+	"github.com/go-kratos/kratos/v2/transport"
+	"github.com/pkg/errors"
+)
+
+// ErrCircuitOpen is returned by CircuitBreaker when the calls to the target are rejected locally.
+var ErrCircuitOpen = kratoserrors.ServiceUnavailable("CIRCUIT_OPEN", "the calls to the target are rejected by the circuit breaker")
+
+// CircuitBreaker is the kratos circuitbreaker.Client middleware with an SRE breaker per target
+// (the client endpoint) instead of per operation, so a failing replica set is isolated as a whole.
+// Server errors and timeouts count as failures, canceled calls are not counted.
+func CircuitBreaker() middleware.Middleware {
+	var breakers sync.Map
+
+	return func(handler middleware.Handler) middleware.Handler {
+		return func(ctx context.Context, req any) (any, error) {
+			tr, ok := transport.FromClientContext(ctx)
+			if !ok {
+				return handler(ctx, req)
+			}
+
+			breaker, isLoaded := breakers.Load(tr.Endpoint())
+			if !isLoaded {
+				breaker, _ = breakers.LoadOrStore(tr.Endpoint(), sre.NewBreaker())
+			}
+
+			cb := breaker.(circuitbreaker.CircuitBreaker)
+
+			if err := cb.Allow(); err != nil {
+				// Rejected calls count as failures too, so the breaker keeps rejecting while the target is down.
+				cb.MarkFailed()
+
+				return nil, ErrCircuitOpen
+			}
+
+			reply, err := handler(ctx, req)
+			if errors.Is(err, context.Canceled) {
+				// The caller gave up on the call, e.g. a hedged call that lost, it says nothing of the target.
+				return reply, err
+			}
+
+			if err != nil && kratoserrors.FromError(err).Code >= http.StatusInternalServerError {
+				cb.MarkFailed()
+			} else {
+				cb.MarkSuccess()
+			}
+
+			return reply, err
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package middleware
+
+import (
+	"context"
+	"math/rand/v2"
+	"net/http"
+	"slices"
+	"time"
+
+	kratoserrors "github.com/go-kratos/kratos/v2/errors"
+	"github.com/go-kratos/kratos/v2/middleware"
+	"github.com/go-kratos/kratos/v2/transport"
+	khttp "github.com/go-kratos/kratos/v2/transport/http"
+	"github.com/pkg/errors"
+)
+
+// RetryConfig configures Retry.
+type RetryConfig struct {
+	// MaxAttempts includes the first one, 1 disables retries.
+	MaxAttempts int
+	// The backoff before the attempt n is random in [0, min(MaxBackoff, BaseBackoff * 2^n)).
+	BaseBackoff time.Duration
+	MaxBackoff  time.Duration
+	// IdempotentOperations are retried in addition to the idempotent HTTP methods
+	// and the requests with an Idempotency-Key header.
+	IdempotentOperations []string
+}
+
+// Retry repeats the idempotent calls that fail with 429, 502, 503 or 504, with a jittered exponential backoff.
+// A call is idempotent if its operation is in cfg.IdempotentOperations, its HTTP method is idempotent
+// or it has an Idempotency-Key header (see Idempotency). The calls rejected by CircuitBreaker are not retried.
+func Retry(cfg RetryConfig) (middleware.Middleware, error) {
+	if cfg.MaxAttempts < 1 {
+		return nil, errors.New("retry max attempts must be positive")
+	}
+
+	if cfg.BaseBackoff <= 0 || cfg.MaxBackoff < cfg.BaseBackoff {
+		return nil, errors.New("retry backoff must be positive and not exceed the max backoff")
+	}
+
+	return func(handler middleware.Handler) middleware.Handler {
+		return func(ctx context.Context, req any) (any, error) {
+			tr, ok := transport.FromClientContext(ctx)
+			if !ok || cfg.MaxAttempts == 1 || !isIdempotentCall(tr, cfg.IdempotentOperations) {
+				return handler(ctx, req)
+			}
+
+			for attempt := 1; ; attempt++ {
+				reply, err := handler(ctx, req)
+				if err == nil || attempt == cfg.MaxAttempts || !isRetryable(err) {
+					return reply, err
+				}
+
+				timer := time.NewTimer(rand.N(cfg.backoff(attempt)))
+
+				select {
+				case <-ctx.Done():
+					timer.Stop()
+
+					return reply, err
+				case <-timer.C:
+				}
+
+				if !rewindBody(tr) {
+					return reply, err
+				}
+			}
+		}
+	}, nil
+}
+
+// backoff returns the upper bound of the backoff after the attempt, it stops doubling at MaxBackoff.
+func (cfg RetryConfig) backoff(attempt int) time.Duration {
+	backoff := cfg.BaseBackoff
+
+	for range attempt - 1 {
+		if backoff > cfg.MaxBackoff/2 {
+			return cfg.MaxBackoff
+		}
+
+		backoff *= 2
+	}
+
+	return backoff
+}
+
+func isIdempotentCall(tr transport.Transporter, operations []string) bool {
+	if slices.Contains(operations, tr.Operation()) || tr.RequestHeader().Get(headerIdempotencyKey) != "" {
+		return true
+	}
+
+	httpTr, ok := tr.(khttp.Transporter)
+	if !ok || httpTr.Request() == nil {
+		return false
+	}
+
+	switch httpTr.Request().Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete:
+		return true
+	default:
+		return false
+	}
+}
+
+func isRetryable(err error) bool {
+	if errors.Is(err, ErrCircuitOpen) {
+		return false
+	}
+
+	switch kratoserrors.FromError(err).Code {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	default:
+		return false
+	}
+}
+
+// rewindBody resets the body of an HTTP request for the next attempt, it returns false if it cannot.
+func rewindBody(tr transport.Transporter) bool {
+	httpTr, ok := tr.(khttp.Transporter)
+	if !ok || httpTr.Request() == nil || httpTr.Request().Body == nil || httpTr.Request().Body == http.NoBody {
+		return true
+	}
+
+	r := httpTr.Request()
+	if r.GetBody == nil {
+		return false
+	}
+
+	body, err := r.GetBody()
+	if err != nil {
+		return false
+	}
+
+	r.Body = body
+
+	return true
+}
@@ -0,0 +1,270 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	kratoserrors "github.com/go-kratos/kratos/v2/errors"
+	"github.com/go-kratos/kratos/v2/middleware"
+	"github.com/go-kratos/kratos/v2/transport"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testClientTransport is an HTTP client transport.
+type testClientTransport struct {
+	endpoint  string
+	operation string
+	request   *http.Request
+}
+
+func (tr *testClientTransport) Kind() transport.Kind            { return transport.KindHTTP }
+func (tr *testClientTransport) Endpoint() string                { return tr.endpoint }
+func (tr *testClientTransport) Operation() string               { return tr.operation }
+func (tr *testClientTransport) RequestHeader() transport.Header { return testHeader(tr.request.Header) }
+func (tr *testClientTransport) ReplyHeader() transport.Header   { return testHeader{} }
+func (tr *testClientTransport) Request() *http.Request          { return tr.request }
+func (tr *testClientTransport) PathTemplate() string            { return "" }
+
+func newClientContext(t *testing.T, endpoint string, method string, body []byte) context.Context {
+	t.Helper()
+
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+
+	req, err := http.NewRequestWithContext(context.Background(), method, "http://"+endpoint+"/orders", reader)
+	require.NoError(t, err)
+
+	return transport.NewClientContext(context.Background(), &testClientTransport{
+		endpoint:  endpoint,
+		operation: "/orders.v1.OrderService/CreateOrder",
+		request:   req,
+	})
+}
+
+func mustCreateRetry(t *testing.T, maxAttempts int) middleware.Middleware {
+	t.Helper()
+
+	retry, err := Retry(RetryConfig{MaxAttempts: maxAttempts, BaseBackoff: time.Microsecond, MaxBackoff: time.Millisecond})
+	require.NoError(t, err)
+
+	return retry
+}
+
+func TestRetry(t *testing.T) {
+	tests := []struct {
+		name           string
+		method         string
+		idempotencyKey string
+		err            error
+		attempts       int32
+	}{
+		{"too many requests", http.MethodGet, "", kratoserrors.New(http.StatusTooManyRequests, "", ""), 3},
+		{"bad gateway", http.MethodGet, "", kratoserrors.New(http.StatusBadGateway, "", ""), 3},
+		{"service unavailable", http.MethodPut, "", kratoserrors.ServiceUnavailable("", ""), 3},
+		{"gateway timeout", http.MethodDelete, "", kratoserrors.GatewayTimeout("", ""), 3},
+		{"internal error", http.MethodGet, "", kratoserrors.InternalServer("", ""), 1},
+		{"bad request", http.MethodGet, "", kratoserrors.BadRequest("", ""), 1},
+		{"circuit open", http.MethodGet, "", ErrCircuitOpen, 1},
+		{"post", http.MethodPost, "", kratoserrors.ServiceUnavailable("", ""), 1},
+		{"post with idempotency key", http.MethodPost, "key-1", kratoserrors.ServiceUnavailable("", ""), 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var attempts atomic.Int32
+
+			ctx := newClientContext(t, "orders:8000", tt.method, nil)
+			if tt.idempotencyKey != "" {
+				tr, _ := transport.FromClientContext(ctx)
+				tr.RequestHeader().Set(headerIdempotencyKey, tt.idempotencyKey)
+			}
+
+			_, err := mustCreateRetry(t, 3)(func(context.Context, any) (any, error) {
+				attempts.Add(1)
+
+				return nil, tt.err
+			})(ctx, nil)
+
+			require.ErrorIs(t, err, tt.err)
+			assert.Equal(t, tt.attempts, attempts.Load())
+		})
+	}
+}
+
+func TestRetryRewindsBody(t *testing.T) {
+	tests := []struct {
+		name     string
+		noRewind bool
+		attempts int
+	}{
+		{"rewindable body", false, 3},
+		{"body without GetBody", true, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := newClientContext(t, "orders:8000", http.MethodPut, []byte("order-1"))
+
+			tr, _ := transport.FromClientContext(ctx)
+			if tt.noRewind {
+				tr.(*testClientTransport).request.GetBody = nil
+			}
+
+			var bodies []string
+
+			_, err := mustCreateRetry(t, 3)(func(context.Context, any) (any, error) {
+				body, err := io.ReadAll(tr.(*testClientTransport).request.Body)
+				require.NoError(t, err)
+
+				bodies = append(bodies, string(body))
+
+				return nil, kratoserrors.ServiceUnavailable("", "")
+			})(ctx, nil)
+
+			require.Error(t, err)
+			assert.Len(t, bodies, tt.attempts)
+
+			for _, body := range bodies {
+				assert.Equal(t, "order-1", body)
+			}
+		})
+	}
+}
+
+func TestRetryBackoff(t *testing.T) {
+	cfg := RetryConfig{MaxAttempts: 1000, BaseBackoff: 50 * time.Millisecond, MaxBackoff: time.Second}
+
+	tests := []struct {
+		attempt int
+		backoff time.Duration
+	}{
+		{1, 50 * time.Millisecond},
+		{2, 100 * time.Millisecond},
+		{5, 800 * time.Millisecond},
+		{6, time.Second},
+		{64, time.Second},
+		{1000, time.Second},
+	}
+
+	for _, tt := range tests {
+		t.Run(strconv.Itoa(tt.attempt), func(t *testing.T) {
+			assert.Equal(t, tt.backoff, cfg.backoff(tt.attempt))
+		})
+	}
+}
+
+func TestHedgeReturnsFirstSuccessAndCancelsOthers(t *testing.T) {
+	var (
+		attempts atomic.Int32
+		canceled = make(chan struct{})
+	)
+
+	reply, err := Hedge(context.Background(), time.Millisecond, 3, func(ctx context.Context) (string, error) {
+		if attempts.Add(1) == 1 {
+			<-ctx.Done()
+			close(canceled)
+
+			return "", ctx.Err()
+		}
+
+		return "second", nil
+	})
+
+	require.NoError(t, err)
+	assert.Equal(t, "second", reply)
+
+	select {
+	case <-canceled:
+	case <-time.After(time.Second):
+		t.Fatal("the slow attempt is not canceled")
+	}
+}
+
+func TestCircuitBreakerOpensPerEndpoint(t *testing.T) {
+	breaker := CircuitBreaker()
+
+	call := func(endpoint string, err error) error {
+		_, callErr := breaker(func(context.Context, any) (any, error) {
+			return nil, err
+		})(newClientContext(t, endpoint, http.MethodGet, nil), nil)
+
+		return callErr
+	}
+
+	tests := []struct {
+		name     string
+		endpoint string
+		err      error
+		isOpen   bool
+	}{
+		{"server errors", "orders:8000", kratoserrors.InternalServer("", ""), true},
+		{"canceled calls", "payments:8000", context.Canceled, false},
+		{"client errors", "users:8000", kratoserrors.NotFound("", ""), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for range 200 {
+				_ = call(tt.endpoint, tt.err)
+			}
+
+			var rejected int
+
+			for range 50 {
+				if errors.Is(call(tt.endpoint, nil), ErrCircuitOpen) {
+					rejected++
+				}
+			}
+
+			if tt.isOpen {
+				assert.Positive(t, rejected)
+			} else {
+				assert.Zero(t, rejected)
+			}
+
+			require.NoError(t, call("inventory:8000", nil), "the breakers of the other endpoints are closed")
+		})
+	}
+}
+
+func TestDeadlineServerShortensDeadlineOfCaller(t *testing.T) {
+	tests := []struct {
+		name      string
+		header    string
+		remaining time.Duration
+	}{
+		{"header of caller", "100", 100 * time.Millisecond},
+		{"deadline of server is sooner", "60000", time.Second},
+		{"invalid header", "soon", time.Second},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tr := &testTransport{request: testHeader{}, reply: testHeader{}}
+			tr.request.Set(headerRequestTimeout, tt.header)
+
+			ctx, cancel := context.WithTimeout(transport.NewServerContext(context.Background(), tr), time.Second)
+			defer cancel()
+
+			_, err := DeadlineServer()(func(ctx context.Context, _ any) (any, error) {
+				deadline, ok := ctx.Deadline()
+				require.True(t, ok)
+				assert.InDelta(t, tt.remaining, time.Until(deadline), float64(50*time.Millisecond))
+
+				return nil, nil
+			})(ctx, nil)
+
+			require.NoError(t, err)
+		})
+	}
+}
@@ -0,0 +1,367 @@
+package middleware
+
+import (
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	kratoserrors "github.com/go-kratos/kratos/v2/errors"
+	khttp "github.com/go-kratos/kratos/v2/transport/http"
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zstd"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	encodingGzip     = "gzip"
+	encodingZstd     = "zstd"
+	encodingIdentity = "identity"
+)
+
+var ErrUnsupportedEncoding = kratoserrors.New(
+	http.StatusUnsupportedMediaType,
+	"UNSUPPORTED_CONTENT_ENCODING",
+	"the content encoding of the request is not supported",
+)
+
+// incompressibleTypes are the content type prefixes that are already compressed.
+var incompressibleTypes = []string{
+	"image/png", "image/jpeg", "image/gif", "image/webp", "image/avif",
+	"video/", "audio/", "font/woff",
+	"application/zip", "application/gzip", "application/x-gzip", "application/zstd",
+	"application/x-7z-compressed", "application/x-rar-compressed", "application/octet-stream",
+}
+
+type compressionMetrics struct {
+	ratio *prometheus.HistogramVec
+	bytes *prometheus.CounterVec
+}
+
+var getCompressionMetrics = sync.OnceValue(func() *compressionMetrics {
+	m := &compressionMetrics{
+		ratio: prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Name:    "http_response_compression_ratio",
+				Help:    "Compressed to original size ratio of the compressed responses by encoding",
+				Buckets: []float64{0.05, 0.1, 0.2, 0.3, 0.4, 0.5, 0.7, 0.9, 1},
+			},
+			[]string{"encoding"},
+		),
+		bytes: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "http_response_compression_bytes_total",
+				Help: "Bytes of the compressed responses by encoding and size (original, compressed)",
+			},
+			[]string{"encoding", "size"},
+		),
+	}
+
+	prometheus.MustRegister(m.ratio, m.bytes)
+
+	return m
+})
+
+var (
+	gzipWriters = sync.Pool{New: func() any {
+		w, _ := gzip.NewWriterLevel(nil, gzip.DefaultCompression)
+
+		return w
+	}}
+	zstdWriters = sync.Pool{New: func() any {
+		w, _ := zstd.NewWriter(nil, zstd.WithEncoderConcurrency(1), zstd.WithEncoderLevel(zstd.SpeedDefault))
+
+		return w
+	}}
+)
+
+// Compression compresses the responses of at least minSize bytes with zstd or gzip, as negotiated
+// by Accept-Encoding, unless their content type is already compressed. It decompresses the gzip
+// and zstd request bodies, so it must precede MaxBodySize to limit the decompressed size.
+func Compression(minSize int) khttp.FilterFunc {
+	metrics := getCompressionMetrics()
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if err := decompressRequest(r); err != nil {
+				khttp.DefaultErrorEncoder(w, r, err)
+
+				return
+			}
+
+			w.Header().Add("Vary", "Accept-Encoding")
+
+			encoding := negotiateEncoding(r.Header.Get("Accept-Encoding"))
+			if encoding == "" || r.Method == http.MethodHead {
+				next.ServeHTTP(w, r)
+
+				return
+			}
+
+			cw := &compressWriter{ResponseWriter: w, encoding: encoding, minSize: minSize, metrics: metrics}
+			defer cw.close()
+
+			next.ServeHTTP(cw, r)
+		})
+	}
+}
+
+func decompressRequest(r *http.Request) error {
+	encoding := strings.ToLower(strings.TrimSpace(r.Header.Get("Content-Encoding")))
+
+	var body io.ReadCloser
+
+	switch encoding {
+	case "", encodingIdentity:
+		return nil
+	case encodingGzip:
+		reader, err := gzip.NewReader(r.Body)
+		if err != nil {
+			return kratoserrors.BadRequest("INVALID_BODY", err.Error())
+		}
+
+		body = reader
+	case encodingZstd:
+		decoder, err := zstd.NewReader(r.Body, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return kratoserrors.BadRequest("INVALID_BODY", err.Error())
+		}
+
+		body = decoder.IOReadCloser()
+	default:
+		return ErrUnsupportedEncoding
+	}
+
+	r.Body = body
+	r.ContentLength = -1
+	r.Header.Del("Content-Encoding")
+	r.Header.Del("Content-Length")
+
+	return nil
+}
+
+// negotiateEncoding returns zstd or gzip, whichever has the higher q-value with zstd on a tie, or "".
+func negotiateEncoding(acceptEncoding string) string {
+	var (
+		best  string
+		bestQ float64
+	)
+
+	for part := range strings.SplitSeq(acceptEncoding, ",") {
+		name, params, _ := strings.Cut(part, ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+
+		q := 1.0
+		if value, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			parsed, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				continue
+			}
+
+			q = parsed
+		}
+
+		if name != encodingZstd && name != encodingGzip || q <= 0 {
+			continue
+		}
+
+		if q > bestQ || q == bestQ && name == encodingZstd {
+			best, bestQ = name, q
+		}
+	}
+
+	return best
+}
+
+// compressWriter buffers the response until minSize bytes to decide whether to compress it.
+type compressWriter struct {
+	http.ResponseWriter
+	encoding string
+	minSize  int
+	metrics  *compressionMetrics
+
+	status     int
+	buf        []byte
+	isDecided  bool
+	encoder    io.WriteCloser
+	original   int64
+	compressed countingWriter
+}
+
+func (cw *compressWriter) WriteHeader(status int) {
+	if cw.status == 0 {
+		cw.status = status
+	}
+}
+
+func (cw *compressWriter) Write(p []byte) (int, error) {
+	if cw.status == 0 {
+		cw.status = http.StatusOK
+	}
+
+	if cw.isDecided {
+		if cw.encoder == nil {
+			return cw.ResponseWriter.Write(p)
+		}
+
+		cw.original += int64(len(p))
+
+		return cw.encoder.Write(p)
+	}
+
+	cw.buf = append(cw.buf, p...)
+	if len(cw.buf) < cw.minSize {
+		return len(p), nil
+	}
+
+	if err := cw.decide(); err != nil {
+		return 0, err
+	}
+
+	return len(p), nil
+}
+
+func (cw *compressWriter) Flush() {
+	if !cw.isDecided {
+		_ = cw.decide()
+	}
+
+	if flusher, ok := cw.encoder.(interface{ Flush() error }); ok {
+		_ = flusher.Flush()
+	}
+
+	if flusher, ok := cw.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (cw *compressWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
+
+// decide writes the header and the buffered body, compressed or not.
+func (cw *compressWriter) decide() error {
+	cw.isDecided = true
+
+	if cw.status == 0 {
+		cw.status = http.StatusOK
+	}
+
+	header := cw.Header()
+	if header.Get("Content-Type") == "" && len(cw.buf) > 0 {
+		header.Set("Content-Type", http.DetectContentType(cw.buf))
+	}
+
+	if len(cw.buf) >= cw.minSize && cw.isCompressible() {
+		header.Set("Content-Encoding", cw.encoding)
+		header.Del("Content-Length")
+
+		cw.compressed.w = cw.ResponseWriter
+		cw.encoder = newEncoder(cw.encoding, &cw.compressed)
+	}
+
+	cw.ResponseWriter.WriteHeader(cw.status)
+
+	buf := cw.buf
+	cw.buf = nil
+
+	if len(buf) == 0 {
+		return nil
+	}
+
+	if cw.encoder == nil {
+		_, err := cw.ResponseWriter.Write(buf)
+
+		return err
+	}
+
+	cw.original += int64(len(buf))
+
+	_, err := cw.encoder.Write(buf)
+
+	return err
+}
+
+func (cw *compressWriter) isCompressible() bool {
+	if cw.status < http.StatusOK || cw.status == http.StatusNoContent || cw.status == http.StatusNotModified {
+		return false
+	}
+
+	header := cw.Header()
+	if header.Get("Content-Encoding") != "" {
+		return false
+	}
+
+	contentType := strings.ToLower(header.Get("Content-Type"))
+	for _, prefix := range incompressibleTypes {
+		if strings.HasPrefix(contentType, prefix) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (cw *compressWriter) close() {
+	if cw.status == 0 {
+		// The handler has written nothing, net/http writes 200 by itself.
+		return
+	}
+
+	if !cw.isDecided {
+		_ = cw.decide()
+	}
+
+	if cw.encoder == nil {
+		return
+	}
+
+	_ = cw.encoder.Close()
+	releaseEncoder(cw.encoding, cw.encoder)
+
+	if cw.original > 0 {
+		cw.metrics.ratio.WithLabelValues(cw.encoding).Observe(float64(cw.compressed.n) / float64(cw.original))
+		cw.metrics.bytes.WithLabelValues(cw.encoding, "original").Add(float64(cw.original))
+		cw.metrics.bytes.WithLabelValues(cw.encoding, "compressed").Add(float64(cw.compressed.n))
+	}
+}
+
+func newEncoder(encoding string, w io.Writer) io.WriteCloser {
+	if encoding == encodingZstd {
+		encoder := zstdWriters.Get().(*zstd.Encoder)
+		encoder.Reset(w)
+
+		return encoder
+	}
+
+	encoder := gzipWriters.Get().(*gzip.Writer)
+	encoder.Reset(w)
+
+	return encoder
+}
+
+func releaseEncoder(encoding string, encoder io.WriteCloser) {
+	if encoding == encodingZstd {
+		encoder.(*zstd.Encoder).Reset(nil)
+		zstdWriters.Put(encoder)
+
+		return
+	}
+
+	encoder.(*gzip.Writer).Reset(nil)
+	gzipWriters.Put(encoder)
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+
+	return n, err
+}
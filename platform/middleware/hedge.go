@@ -0,0 +1,75 @@
+package middleware
+
+import (
+	"context"
+	"time"
+)
+
+type hedgeResult[T any] struct {
+	reply T
+	err   error
+}
+
+// Hedge sends the call again if it has not completed after delay, up to maxAttempts in flight,
+// and returns the first successful reply, cancelling the others, or the error of the last one.
+// Failed attempts are not repeated, it is the job of Retry.
+// It wraps a call of a generated kratos client, which has its own reply for every attempt,
+// so it is not a middleware, e.g.
+//
+//	reply, err := middleware.Hedge(ctx, 50*time.Millisecond, 2, func(ctx context.Context) (*v1.Product, error) {
+//		return client.GetProduct(ctx, req)
+//	})
+//
+// Only idempotent calls can be hedged.
+func Hedge[T any](
+	ctx context.Context,
+	delay time.Duration,
+	maxAttempts int,
+	call func(ctx context.Context) (T, error),
+) (T, error) {
+	if maxAttempts <= 1 {
+		return call(ctx)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	results := make(chan hedgeResult[T], maxAttempts)
+	attempt := func() {
+		reply, err := call(ctx)
+		results <- hedgeResult[T]{reply: reply, err: err}
+	}
+
+	go attempt()
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	var (
+		inFlight = 1
+		started  = 1
+		last     hedgeResult[T]
+	)
+
+	for inFlight > 0 {
+		select {
+		case <-timer.C:
+			if started < maxAttempts {
+				started++
+				inFlight++
+
+				go attempt()
+
+				timer.Reset(delay)
+			}
+		case last = <-results:
+			inFlight--
+
+			if last.err == nil {
+				return last.reply, nil
+			}
+		}
+	}
+
+	return last.reply, last.err
+}
@@ -0,0 +1,239 @@
+package middleware
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"platform/logger"
+
+	"github.com/caarlos0/env/v11"
+	kratoserrors "github.com/go-kratos/kratos/v2/errors"
+	khttp "github.com/go-kratos/kratos/v2/transport/http"
+	"github.com/pkg/errors"
+)
+
+var (
+	ErrRequestTooLarge = kratoserrors.New(
+		http.StatusRequestEntityTooLarge,
+		"REQUEST_TOO_LARGE",
+		"the request body exceeds the limit",
+	)
+	ErrCORSNotAllowed = kratoserrors.Forbidden("CORS_NOT_ALLOWED", "the cross-origin request is not allowed")
+)
+
+// CORSConfig is the CORS policy, an empty AllowedOrigins disables CORS.
+type CORSConfig struct {
+	// AllowedOrigins are origins such as https://shop.example.com, https://*.example.com for the subdomains
+	// or * for any origin, which cannot be used with AllowCredentials.
+	AllowedOrigins   []string      `env:"CORS_ALLOWED_ORIGINS"`
+	AllowedMethods   []string      `env:"CORS_ALLOWED_METHODS" envDefault:"GET,POST,PUT,PATCH,DELETE"`
+	AllowedHeaders   []string      `env:"CORS_ALLOWED_HEADERS" envDefault:"Authorization,Content-Type,Idempotency-Key,X-Request-ID"`
+	ExposedHeaders   []string      `env:"CORS_EXPOSED_HEADERS" envDefault:"ETag,Idempotent-Replayed,RateLimit-Limit,RateLimit-Remaining,RateLimit-Reset,Retry-After,X-Request-ID"`
+	AllowCredentials bool          `env:"CORS_ALLOW_CREDENTIALS"`
+	MaxAge           time.Duration `env:"CORS_MAX_AGE" envDefault:"10m"`
+}
+
+// SecurityHeadersConfig configures SecurityHeaders, an empty value omits its header.
+type SecurityHeadersConfig struct {
+	HSTSMaxAge            time.Duration `env:"SECURITY_HSTS_MAX_AGE" envDefault:"8760h"`
+	HSTSIncludeSubdomains bool          `env:"SECURITY_HSTS_INCLUDE_SUBDOMAINS" envDefault:"true"`
+	FrameOptions          string        `env:"SECURITY_FRAME_OPTIONS" envDefault:"DENY"`
+	ContentSecurityPolicy string        `env:"SECURITY_CONTENT_SECURITY_POLICY" envDefault:"default-src 'none'; frame-ancestors 'none'"`
+	ReferrerPolicy        string        `env:"SECURITY_REFERRER_POLICY" envDefault:"no-referrer"`
+}
+
+type httpFiltersConfig struct {
+	CORS            CORSConfig
+	SecurityHeaders SecurityHeadersConfig
+	MaxBodyBytes    int64 `env:"HTTP_MAX_BODY_BYTES" envDefault:"1048576"`
+	// CompressionMinBytes is the size from which the responses are compressed, zero disables Compression.
+	CompressionMinBytes int `env:"HTTP_COMPRESSION_MIN_BYTES" envDefault:"1024"`
+}
+
+// MustCreateHTTPFilters returns SecurityHeaders, CORS, Compression and MaxBodySize configured
+// from the environment, for the http.Filter option of a kratos server.
+func MustCreateHTTPFilters() []khttp.FilterFunc {
+	cfg, err := env.ParseAs[httpFiltersConfig]()
+	if err != nil {
+		logger.Fatal(err.Error())
+
+		return nil
+	}
+
+	cors, err := CORS(cfg.CORS)
+	if err != nil {
+		logger.Fatal(err.Error())
+
+		return nil
+	}
+
+	filters := []khttp.FilterFunc{SecurityHeaders(cfg.SecurityHeaders), cors}
+	if cfg.CompressionMinBytes > 0 {
+		filters = append(filters, Compression(cfg.CompressionMinBytes))
+	}
+
+	return append(filters, MaxBodySize(cfg.MaxBodyBytes))
+}
+
+// SecurityHeaders sets Strict-Transport-Security, X-Content-Type-Options, X-Frame-Options,
+// Content-Security-Policy and Referrer-Policy on every response.
+func SecurityHeaders(cfg SecurityHeadersConfig) khttp.FilterFunc {
+	headers := map[string]string{
+		"X-Content-Type-Options":  "nosniff",
+		"X-Frame-Options":         cfg.FrameOptions,
+		"Content-Security-Policy": cfg.ContentSecurityPolicy,
+		"Referrer-Policy":         cfg.ReferrerPolicy,
+	}
+
+	if cfg.HSTSMaxAge > 0 {
+		hsts := "max-age=" + strconv.FormatInt(int64(cfg.HSTSMaxAge.Seconds()), 10)
+		if cfg.HSTSIncludeSubdomains {
+			hsts += "; includeSubDomains"
+		}
+
+		headers["Strict-Transport-Security"] = hsts
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			for key, value := range headers {
+				if value != "" {
+					w.Header().Set(key, value)
+				}
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// CORS answers the preflight requests of the allowed origins with 204 and of the others with ErrCORSNotAllowed,
+// and adds the CORS headers to the responses of the allowed origins.
+func CORS(cfg CORSConfig) (khttp.FilterFunc, error) {
+	isAnyOrigin := slices.Contains(cfg.AllowedOrigins, "*")
+	if isAnyOrigin && cfg.AllowCredentials {
+		return nil, errors.New("cors allowed origins cannot be * with credentials")
+	}
+
+	var (
+		allowedMethods = strings.Join(cfg.AllowedMethods, ", ")
+		allowedHeaders = strings.Join(cfg.AllowedHeaders, ", ")
+		exposedHeaders = strings.Join(cfg.ExposedHeaders, ", ")
+		maxAge         = strconv.FormatInt(int64(cfg.MaxAge.Seconds()), 10)
+	)
+
+	return func(next http.Handler) http.Handler {
+		if len(cfg.AllowedOrigins) == 0 {
+			return next
+		}
+
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			origin := r.Header.Get("Origin")
+			if origin == "" {
+				next.ServeHTTP(w, r)
+
+				return
+			}
+
+			header := w.Header()
+			header.Add("Vary", "Origin")
+
+			isPreflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+			isAllowed := isAnyOrigin || slices.ContainsFunc(cfg.AllowedOrigins, func(allowed string) bool {
+				return originMatches(allowed, origin)
+			})
+
+			if !isAllowed {
+				if isPreflight {
+					khttp.DefaultErrorEncoder(w, r, ErrCORSNotAllowed)
+
+					return
+				}
+
+				next.ServeHTTP(w, r)
+
+				return
+			}
+
+			if isAnyOrigin {
+				header.Set("Access-Control-Allow-Origin", "*")
+			} else {
+				header.Set("Access-Control-Allow-Origin", origin)
+			}
+
+			if cfg.AllowCredentials {
+				header.Set("Access-Control-Allow-Credentials", "true")
+			}
+
+			if !isPreflight {
+				if exposedHeaders != "" {
+					header.Set("Access-Control-Expose-Headers", exposedHeaders)
+				}
+
+				next.ServeHTTP(w, r)
+
+				return
+			}
+
+			header.Add("Vary", "Access-Control-Request-Method")
+			header.Add("Vary", "Access-Control-Request-Headers")
+			header.Set("Access-Control-Allow-Methods", allowedMethods)
+			header.Set("Access-Control-Allow-Headers", allowedHeaders)
+			header.Set("Access-Control-Max-Age", maxAge)
+
+			w.WriteHeader(http.StatusNoContent)
+		})
+	}, nil
+}
+
+// originMatches compares the origins case-insensitively, a * in the host of allowed matches any subdomains.
+func originMatches(allowed string, origin string) bool {
+	allowed, origin = strings.ToLower(allowed), strings.ToLower(origin)
+
+	prefix, suffix, isWildcard := strings.Cut(allowed, "*")
+	if !isWildcard {
+		return allowed == origin
+	}
+
+	return len(origin) > len(prefix)+len(suffix) && strings.HasPrefix(origin, prefix) && strings.HasSuffix(origin, suffix)
+}
+
+// MaxBodySize rejects the requests with a body over maxBytes with ErrRequestTooLarge (413).
+// A body without Content-Length is read up to the limit before the handler.
+func MaxBodySize(maxBytes int64) khttp.FilterFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.ContentLength > maxBytes {
+				khttp.DefaultErrorEncoder(w, r, ErrRequestTooLarge)
+
+				return
+			}
+
+			if r.ContentLength < 0 && r.Body != nil && r.Body != http.NoBody {
+				data, err := io.ReadAll(io.LimitReader(r.Body, maxBytes+1))
+				if err != nil {
+					khttp.DefaultErrorEncoder(w, r, kratoserrors.BadRequest("INVALID_BODY", err.Error()))
+
+					return
+				}
+
+				if int64(len(data)) > maxBytes {
+					khttp.DefaultErrorEncoder(w, r, ErrRequestTooLarge)
+
+					return
+				}
+
+				r.Body = io.NopCloser(bytes.NewReader(data))
+			}
+
+			r.Body = http.MaxBytesReader(w, r.Body, maxBytes)
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,275 @@
+package middleware
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"time"
+
+	"platform/cache"
+	"platform/logger"
+
+	"github.com/caarlos0/env/v11"
+	kratoserrors "github.com/go-kratos/kratos/v2/errors"
+	"github.com/go-kratos/kratos/v2/middleware"
+	"github.com/go-kratos/kratos/v2/transport"
+	khttp "github.com/go-kratos/kratos/v2/transport/http"
+	"github.com/goccy/go-json"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+)
+
+const (
+	headerIdempotencyKey      = "Idempotency-Key"
+	headerIdempotentReplayed  = "Idempotent-Replayed"
+	idempotencyTable          = "idempotency"
+	idempotencyStateInFlight  = "in_flight"
+	idempotencyStateCompleted = "completed"
+)
+
+var (
+	ErrIdempotencyInFlight = kratoserrors.Conflict(
+		"IDEMPOTENCY_KEY_IN_FLIGHT",
+		"a request with the same idempotency key is in progress",
+	)
+	ErrIdempotencyKeyReused = kratoserrors.New(
+		http.StatusUnprocessableEntity,
+		"IDEMPOTENCY_KEY_REUSED",
+		"the idempotency key was used with a different request",
+	)
+	ErrIdempotencyUnauthenticated = kratoserrors.Unauthorized(
+		"IDEMPOTENCY_KEY_UNAUTHENTICATED",
+		"an idempotency key requires an authenticated user",
+	)
+	ErrIdempotencyUnavailable = kratoserrors.ServiceUnavailable(
+		"IDEMPOTENCY_UNAVAILABLE",
+		"idempotency storage is unavailable",
+	)
+)
+
+type idempotencyConfig struct {
+	TTL     time.Duration `env:"IDEMPOTENCY_TTL" envDefault:"24h"`
+	LockTTL time.Duration `env:"IDEMPOTENCY_LOCK_TTL" envDefault:"1m"`
+}
+
+// idempotencyRecord is stored by the key: in flight until the handler returns, then with its response.
+type idempotencyRecord struct {
+	State       string `json:"state"`
+	Fingerprint string `json:"fingerprint"`
+	ReplyType   string `json:"reply_type,omitempty"`
+	Reply       []byte `json:"reply,omitempty"`
+	ErrCode     int32  `json:"err_code,omitempty"`
+	ErrReason   string `json:"err_reason,omitempty"`
+	ErrMessage  string `json:"err_message,omitempty"`
+}
+
+// MustCreateIdempotency creates Idempotency with IDEMPOTENCY_TTL (24h by default) for the responses
+// and IDEMPOTENCY_LOCK_TTL (1m by default) for the requests in flight.
+func MustCreateIdempotency(c cache.Cache) middleware.Middleware {
+	cfg, err := env.ParseAs[idempotencyConfig]()
+	if err != nil {
+		logger.Fatal(err.Error())
+
+		return nil
+	}
+
+	return Idempotency(c, cfg.TTL, cfg.LockTTL)
+}
+
+// Idempotency handles the requests with an Idempotency-Key header once per key, user and operation.
+// The first response is stored for ttl and returned to the replays of the key with the Idempotent-Replayed header;
+// a duplicate in flight gets ErrIdempotencyInFlight and a different request under the key gets ErrIdempotencyKeyReused.
+// A key sent without an authenticated user gets ErrIdempotencyUnauthenticated.
+// Server errors are not stored, so the request can be retried. Safe HTTP methods are not affected.
+func Idempotency(c cache.Cache, ttl, lockTTL time.Duration) middleware.Middleware {
+	return func(handler middleware.Handler) middleware.Handler {
+		return func(ctx context.Context, req any) (any, error) {
+			tr, ok := transport.FromServerContext(ctx)
+			if !ok {
+				return handler(ctx, req)
+			}
+
+			idempotencyKey := tr.RequestHeader().Get(headerIdempotencyKey)
+			if idempotencyKey == "" || isSafeHTTPMethod(ctx) {
+				return handler(ctx, req)
+			}
+
+			// The keys are scoped by the user, the anonymous clients would share their replays.
+			user, _ := ctx.Value(userKey{}).(string)
+			if user == "" {
+				return nil, ErrIdempotencyUnauthenticated
+			}
+
+			key := hashParts(user, tr.Operation(), idempotencyKey)
+
+			fingerprint, err := requestFingerprint(req)
+			if err != nil {
+				return nil, kratoserrors.BadRequest("INVALID_REQUEST", err.Error())
+			}
+
+			lock, _ := json.Marshal(idempotencyRecord{State: idempotencyStateInFlight, Fingerprint: fingerprint})
+
+			isLocked, err := c.SetBytesIfNotExists(ctx, idempotencyTable, key, lock, lockTTL)
+			if err != nil {
+				logger.Errorf("error occurred when locking an idempotency key: %v", err)
+
+				return nil, ErrIdempotencyUnavailable
+			}
+
+			if !isLocked {
+				return replayIdempotent(ctx, c, tr, key, fingerprint)
+			}
+
+			reply, err := handler(ctx, req)
+
+			record, ok := completedRecord(fingerprint, reply, err)
+			if !ok {
+				if delErr := c.Delete(context.WithoutCancel(ctx), idempotencyTable, key); delErr != nil {
+					logger.Errorf("error occurred when unlocking an idempotency key: %v", delErr)
+				}
+
+				return reply, err
+			}
+
+			data, _ := json.Marshal(record)
+			if setErr := c.SetBytesWithTTL(context.WithoutCancel(ctx), idempotencyTable, key, data, ttl); setErr != nil {
+				logger.Errorf("error occurred when storing an idempotent response: %v", setErr)
+			}
+
+			return reply, err
+		}
+	}
+}
+
+func replayIdempotent(
+	ctx context.Context,
+	c cache.Cache,
+	tr transport.Transporter,
+	key string,
+	fingerprint string,
+) (any, error) {
+	data, ok := c.GetBytes(ctx, idempotencyTable, key)
+	if !ok {
+		// The lock has just expired or been released, the client can retry.
+		return nil, ErrIdempotencyInFlight
+	}
+
+	var record idempotencyRecord
+
+	if err := json.Unmarshal(data, &record); err != nil {
+		logger.Errorf("error occurred when unmarshalling an idempotency record: %v", err)
+
+		return nil, ErrIdempotencyUnavailable
+	}
+
+	if record.Fingerprint != fingerprint {
+		return nil, ErrIdempotencyKeyReused
+	}
+
+	if record.State != idempotencyStateCompleted {
+		return nil, ErrIdempotencyInFlight
+	}
+
+	tr.ReplyHeader().Set(headerIdempotentReplayed, "true")
+
+	if record.ErrCode != 0 {
+		return nil, kratoserrors.New(int(record.ErrCode), record.ErrReason, record.ErrMessage)
+	}
+
+	msgType, err := protoregistry.GlobalTypes.FindMessageByName(protoreflect.FullName(record.ReplyType))
+	if err != nil {
+		logger.Errorf("error occurred when finding an idempotent reply type: %v", err)
+
+		return nil, ErrIdempotencyUnavailable
+	}
+
+	reply := msgType.New().Interface()
+	if err = proto.Unmarshal(record.Reply, reply); err != nil {
+		logger.Errorf("error occurred when unmarshalling an idempotent reply: %v", err)
+
+		return nil, ErrIdempotencyUnavailable
+	}
+
+	return reply, nil
+}
+
+// completedRecord returns the record to store or false if the response must not be stored.
+func completedRecord(fingerprint string, reply any, err error) (idempotencyRecord, bool) {
+	record := idempotencyRecord{State: idempotencyStateCompleted, Fingerprint: fingerprint}
+
+	if err != nil {
+		kratosErr := kratoserrors.FromError(err)
+		if kratosErr.Code >= http.StatusInternalServerError {
+			return record, false
+		}
+
+		record.ErrCode = kratosErr.Code
+		record.ErrReason = kratosErr.Reason
+		record.ErrMessage = kratosErr.Message
+
+		return record, true
+	}
+
+	msg, ok := reply.(proto.Message)
+	if !ok {
+		return record, false
+	}
+
+	data, marshalErr := proto.MarshalOptions{Deterministic: true}.Marshal(msg)
+	if marshalErr != nil {
+		return record, false
+	}
+
+	record.ReplyType = string(msg.ProtoReflect().Descriptor().FullName())
+	record.Reply = data
+
+	return record, true
+}
+
+func requestFingerprint(req any) (string, error) {
+	var (
+		data []byte
+		err  error
+	)
+
+	if msg, ok := req.(proto.Message); ok {
+		data, err = proto.MarshalOptions{Deterministic: true}.Marshal(msg)
+	} else {
+		data, err = json.Marshal(req)
+	}
+
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256(data)
+
+	return hex.EncodeToString(sum[:]), nil
+}
+
+func hashParts(parts ...string) string {
+	hash := sha256.New()
+
+	for _, part := range parts {
+		hash.Write([]byte(part))
+		hash.Write([]byte{0})
+	}
+
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+func isSafeHTTPMethod(ctx context.Context) bool {
+	req, ok := khttp.RequestFromServerContext(ctx)
+	if !ok {
+		return false
+	}
+
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,58 @@
+package middleware
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-kratos/kratos/v2/transport"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+func TestIdempotencyScopesKeysByUser(t *testing.T) {
+	var calls int
+
+	handler := Idempotency(&mapCache{values: make(map[string][]byte)}, time.Minute, time.Minute)(
+		func(_ context.Context, req any) (any, error) {
+			calls++
+
+			return req, nil
+		},
+	)
+
+	call := func(user string) (testHeader, error) {
+		tr := &testTransport{
+			operation: "/orders.v1.OrderService/CreateOrder",
+			request:   testHeader{headerIdempotencyKey: {"key-1"}},
+			reply:     testHeader{},
+		}
+
+		ctx := transport.NewServerContext(context.Background(), tr)
+		if user != "" {
+			ctx = ContextWithUser(ctx, user)
+		}
+
+		_, err := handler(ctx, wrapperspb.String("order-1"))
+
+		return tr.reply, err
+	}
+
+	_, err := call("")
+	require.ErrorIs(t, err, ErrIdempotencyUnauthenticated)
+	assert.Zero(t, calls)
+
+	reply, err := call("alice")
+	require.NoError(t, err)
+	assert.Empty(t, reply.Get(headerIdempotentReplayed))
+
+	reply, err = call("alice")
+	require.NoError(t, err)
+	assert.Equal(t, "true", reply.Get(headerIdempotentReplayed))
+
+	reply, err = call("bob")
+	require.NoError(t, err)
+	assert.Empty(t, reply.Get(headerIdempotentReplayed))
+	assert.Equal(t, 2, calls)
+}
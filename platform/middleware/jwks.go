@@ -0,0 +1,239 @@
+package middleware
+
+import (
+	"context"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"encoding/base64"
+	"io"
+	"math/big"
+	"net/http"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"platform/logger"
+
+	"github.com/goccy/go-json"
+	"github.com/pkg/errors"
+	"golang.org/x/sync/singleflight"
+)
+
+// jwksMinRefreshInterval bounds the refreshes requested by tokens with an unknown key id.
+const jwksMinRefreshInterval = 30 * time.Second
+
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Crv string `json:"crv"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	X   string `json:"x"`
+}
+
+// jwks is a set of public keys loaded from a local file or a URL and refreshed periodically.
+type jwks struct {
+	file string
+	url  string
+
+	keys atomic.Pointer[map[string]crypto.PublicKey]
+	// refreshes shares a refresh between the requests that need it at the same time.
+	refreshes singleflight.Group
+
+	mu          sync.Mutex
+	lastRefresh time.Time
+}
+
+func newJWKS(ctx context.Context, file, url string, refreshInterval time.Duration) (*jwks, error) {
+	set := &jwks{file: file, url: url}
+
+	if err := set.refresh(ctx); err != nil {
+		return nil, err
+	}
+
+	if refreshInterval > 0 {
+		go set.refreshEvery(ctx, refreshInterval)
+	}
+
+	return set, nil
+}
+
+// key returns the key by id, an empty id matches the only key of the set.
+// An unknown id refreshes the set at most once per jwksMinRefreshInterval, so rotated keys are picked up
+// and tokens with made-up ids cannot flood the key server.
+func (set *jwks) key(ctx context.Context, kid string) (crypto.PublicKey, bool) {
+	if key, ok := set.lookup(kid); ok {
+		return key, true
+	}
+
+	if err := set.refreshShared(ctx, jwksMinRefreshInterval); err != nil {
+		logger.Errorf("error occurred when refreshing JWKS: %v", err)
+
+		return nil, false
+	}
+
+	return set.lookup(kid)
+}
+
+// refreshShared refreshes the set unless it was refreshed within minInterval. The concurrent callers wait
+// for the same refresh, which is not canceled with the context of the first one.
+func (set *jwks) refreshShared(ctx context.Context, minInterval time.Duration) error {
+	_, err, _ := set.refreshes.Do("refresh", func() (any, error) {
+		set.mu.Lock()
+		isRefreshable := time.Since(set.lastRefresh) >= minInterval
+		set.mu.Unlock()
+
+		if !isRefreshable {
+			return nil, nil
+		}
+
+		return nil, set.refresh(context.WithoutCancel(ctx))
+	})
+
+	return err
+}
+
+func (set *jwks) lookup(kid string) (crypto.PublicKey, bool) {
+	keys := *set.keys.Load()
+
+	if kid == "" && len(keys) == 1 {
+		for _, key := range keys {
+			return key, true
+		}
+	}
+
+	key, ok := keys[kid]
+
+	return key, ok
+}
+
+func (set *jwks) refreshEvery(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := set.refreshShared(ctx, 0); err != nil {
+				logger.Errorf("error occurred when refreshing JWKS: %v", err)
+			}
+		}
+	}
+}
+
+func (set *jwks) refresh(ctx context.Context) error {
+	set.mu.Lock()
+	set.lastRefresh = time.Now()
+	set.mu.Unlock()
+
+	data, err := set.read(ctx)
+	if err != nil {
+		return err
+	}
+
+	var doc struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+
+	if err = json.Unmarshal(data, &doc); err != nil {
+		return errors.Wrap(err, "error occurred when unmarshalling JWKS")
+	}
+
+	keys := make(map[string]crypto.PublicKey, len(doc.Keys))
+
+	for _, jwk := range doc.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+
+		key, err := jwk.publicKey()
+		if err != nil {
+			return errors.Wrapf(err, "error occurred when parsing JWKS key %q", jwk.Kid)
+		}
+
+		keys[jwk.Kid] = key
+	}
+
+	set.keys.Store(&keys)
+
+	return nil
+}
+
+func (set *jwks) read(ctx context.Context) ([]byte, error) {
+	if set.file != "" {
+		data, err := os.ReadFile(set.file)
+		if err != nil {
+			return nil, errors.Wrap(err, "error occurred when reading JWKS file")
+		}
+
+		return data, nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, set.url, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "error occurred when creating JWKS request")
+	}
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, errors.Wrap(err, "error occurred when fetching JWKS")
+	}
+
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, errors.Errorf("error occurred when fetching JWKS: status %d", res.StatusCode)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(res.Body, 1<<20))
+	if err != nil {
+		return nil, errors.Wrap(err, "error occurred when reading JWKS")
+	}
+
+	return data, nil
+}
+
+// publicKey supports RSA keys for RS256 and Ed25519 keys for EdDSA.
+func (jwk jsonWebKey) publicKey() (crypto.PublicKey, error) {
+	switch jwk.Kty {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(jwk.N)
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid modulus")
+		}
+
+		e, err := base64.RawURLEncoding.DecodeString(jwk.E)
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid exponent")
+		}
+
+		exponent := new(big.Int).SetBytes(e)
+		if !exponent.IsInt64() || exponent.Int64() > 1<<31-1 {
+			return nil, errors.New("exponent is too large")
+		}
+
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(exponent.Int64())}, nil
+	case "OKP":
+		if jwk.Crv != "Ed25519" {
+			return nil, errors.Errorf("unsupported curve %s", jwk.Crv)
+		}
+
+		x, err := base64.RawURLEncoding.DecodeString(jwk.X)
+		if err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid Ed25519 key")
+		}
+
+		return ed25519.PublicKey(x), nil
+	default:
+		return nil, errors.Errorf("unsupported key type %s", jwk.Kty)
+	}
+}
@@ -0,0 +1,62 @@
+package middleware
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestJWKSRefreshesOnceForConcurrentUnknownKeyIDs(t *testing.T) {
+	public, _, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	var fetches atomic.Int32
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		kid := "old"
+		if fetches.Add(1) > 1 {
+			kid = "rotated"
+
+			time.Sleep(50 * time.Millisecond)
+		}
+
+		_, _ = fmt.Fprintf(w, `{"keys":[{"kty":"OKP","crv":"Ed25519","kid":%q,"x":%q}]}`,
+			kid, base64.RawURLEncoding.EncodeToString(public))
+	}))
+	defer server.Close()
+
+	set, err := newJWKS(context.Background(), "", server.URL, 0)
+	require.NoError(t, err)
+
+	// The key was rotated long after the last refresh.
+	set.lastRefresh = time.Time{}
+
+	var wg sync.WaitGroup
+
+	for range 50 {
+		wg.Go(func() {
+			_, ok := set.key(context.Background(), "rotated")
+			assert.True(t, ok)
+		})
+	}
+
+	wg.Wait()
+
+	assert.Equal(t, int32(2), fetches.Load())
+
+	// A made-up key id does not refresh again within jwksMinRefreshInterval.
+	_, ok := set.key(context.Background(), "unknown")
+	assert.False(t, ok)
+	assert.Equal(t, int32(2), fetches.Load())
+}
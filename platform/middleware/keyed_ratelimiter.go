@@ -0,0 +1,377 @@
+package middleware
+
+import (
+	"container/list"
+	"context"
+	"net"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"platform/logger"
+	"platform/tenant"
+
+	"github.com/caarlos0/env/v11"
+	"github.com/go-kratos/kratos/v2/middleware"
+	"github.com/go-kratos/kratos/v2/middleware/ratelimit"
+	"github.com/go-kratos/kratos/v2/transport"
+	"github.com/go-kratos/kratos/v2/transport/http"
+	"github.com/goccy/go-json"
+	"github.com/pkg/errors"
+	"github.com/redis/rueidis"
+	"google.golang.org/grpc/peer"
+)
+
+type RateLimitKey string
+
+const (
+	RateLimitByIP        RateLimitKey = "ip"
+	RateLimitByAPIKey    RateLimitKey = "api_key"
+	RateLimitByUser      RateLimitKey = "user"
+	RateLimitByOperation RateLimitKey = "operation"
+	RateLimitByTenant    RateLimitKey = "tenant"
+)
+
+var (
+	// AnonymousRateLimitKeys do not need Auth, their limiter can precede it.
+	AnonymousRateLimitKeys = []RateLimitKey{RateLimitByIP, RateLimitByAPIKey, RateLimitByOperation}
+	// SubjectRateLimitKeys need the user of Auth and the tenant of Tenant, their limiter must follow them.
+	SubjectRateLimitKeys = []RateLimitKey{RateLimitByUser, RateLimitByTenant}
+)
+
+// RateLimitRule is a token bucket per key and per operation.
+type RateLimitRule struct {
+	// Operation is the kratos operation the rule applies to,
+	// empty means every operation without its own rule (each operation still has its own budget).
+	Operation string       `json:"operation"`
+	Key       RateLimitKey `json:"key"`
+	// RequestsPerSecond is the refill rate, Burst is the bucket size, zero means RequestsPerSecond.
+	RequestsPerSecond float64 `json:"rps"`
+	Burst             int     `json:"burst"`
+}
+
+type RateLimitConfig struct {
+	Rules []RateLimitRule
+	// Keys restricts the limiter to the rules of these keys, empty means all of them. A rule of another key
+	// still replaces the default rule for its operation, so the limiters of AnonymousRateLimitKeys and
+	// SubjectRateLimitKeys apply one rule to every operation together.
+	Keys []RateLimitKey
+	// MaxKeys bounds the number of buckets of every rule, the least recently used ones are dropped.
+	MaxKeys int
+	// APIKeyHeader is the header read by RateLimitByAPIKey, TenantHeader is read by RateLimitByTenant
+	// when the Tenant middleware has not resolved the tenant.
+	APIKeyHeader string
+	TenantHeader string
+	// TrustForwardedFor makes RateLimitByIP use the last X-Forwarded-For address,
+	// it must be set only behind a proxy that appends it.
+	TrustForwardedFor bool
+	// Redis shares the buckets between the replicas (see RedisRateLimiter), nil keeps them in memory.
+	Redis rueidis.Client
+}
+
+type rateLimitConfig struct {
+	Rules             string `env:"RATE_LIMIT_RULES" envDefault:"[]"`
+	MaxKeys           int    `env:"RATE_LIMIT_MAX_KEYS" envDefault:"100000"`
+	APIKeyHeader      string `env:"RATE_LIMIT_API_KEY_HEADER" envDefault:"X-API-Key"`
+	TenantHeader      string `env:"RATE_LIMIT_TENANT_HEADER" envDefault:"X-Tenant-ID"`
+	TrustForwardedFor bool   `env:"RATE_LIMIT_TRUST_FORWARDED_FOR"`
+}
+
+// LoadRateLimitConfig reads RateLimitConfig from the environment:
+// RATE_LIMIT_RULES is a JSON array of RateLimitRule, e.g. [{"key":"ip","rps":20,"burst":40}],
+// RATE_LIMIT_MAX_KEYS, RATE_LIMIT_API_KEY_HEADER, RATE_LIMIT_TENANT_HEADER and RATE_LIMIT_TRUST_FORWARDED_FOR
+// fill the other fields.
+func LoadRateLimitConfig() (RateLimitConfig, error) {
+	cfg, err := env.ParseAs[rateLimitConfig]()
+	if err != nil {
+		return RateLimitConfig{}, err
+	}
+
+	rlCfg := RateLimitConfig{
+		MaxKeys:           cfg.MaxKeys,
+		APIKeyHeader:      cfg.APIKeyHeader,
+		TenantHeader:      cfg.TenantHeader,
+		TrustForwardedFor: cfg.TrustForwardedFor,
+	}
+
+	if err = json.Unmarshal([]byte(cfg.Rules), &rlCfg.Rules); err != nil {
+		return RateLimitConfig{}, errors.Wrap(err, "error occurred when unmarshalling rate limit rules")
+	}
+
+	return rlCfg, nil
+}
+
+type userKey struct{}
+
+// ContextWithUser stores the authenticated user for RateLimitByUser.
+func ContextWithUser(ctx context.Context, user string) context.Context {
+	return context.WithValue(ctx, userKey{}, user)
+}
+
+// MustCreateKeyedRateLimiter creates KeyedRateLimiter of the rules of keys (all if empty) from the environment,
+// redis may be nil.
+func MustCreateKeyedRateLimiter(ctx context.Context, redis rueidis.Client, keys ...RateLimitKey) middleware.Middleware {
+	cfg, err := LoadRateLimitConfig()
+	if err != nil {
+		logger.Fatal(err.Error())
+
+		return nil
+	}
+
+	cfg.Redis = redis
+	cfg.Keys = keys
+
+	m, err := KeyedRateLimiter(ctx, cfg)
+	if err != nil {
+		logger.Fatal(err.Error())
+
+		return nil
+	}
+
+	return m
+}
+
+// KeyedRateLimiter limits requests by the rules of cfg and returns ratelimit.ErrLimitExceed over the limit.
+// A request without an API key, a user or a tenant is limited by its client IP.
+// The idle buckets are dropped until ctx is done.
+func KeyedRateLimiter(ctx context.Context, cfg RateLimitConfig) (middleware.Middleware, error) {
+	if cfg.MaxKeys <= 0 {
+		return nil, errors.New("rate limit max keys must be positive")
+	}
+
+	var (
+		defaultLimiter *ruleLimiter
+		limiters       = make(map[string]*ruleLimiter, len(cfg.Rules))
+	)
+
+	for _, rule := range cfg.Rules {
+		switch rule.Key {
+		case RateLimitByIP, RateLimitByAPIKey, RateLimitByUser, RateLimitByOperation, RateLimitByTenant:
+		default:
+			return nil, errors.Errorf("unknown rate limit key: %s", rule.Key)
+		}
+
+		if rule.RequestsPerSecond <= 0 {
+			return nil, errors.Errorf("rate limit of operation %q must be positive", rule.Operation)
+		}
+
+		// The limiter of a rule of another key stays nil, the requests of its operation pass.
+		var limiter *ruleLimiter
+
+		if len(cfg.Keys) == 0 || slices.Contains(cfg.Keys, rule.Key) {
+			local := newKeyedLimiter(rule, cfg.MaxKeys)
+			go local.sweep(ctx)
+
+			limiter = &ruleLimiter{rule: rule, keyLimiter: local}
+			if cfg.Redis != nil {
+				limiter.keyLimiter = newRedisKeyLimiter(cfg.Redis, rule, local)
+			}
+		}
+
+		if rule.Operation == "" {
+			defaultLimiter = limiter
+		} else {
+			limiters[rule.Operation] = limiter
+		}
+	}
+
+	return func(handler middleware.Handler) middleware.Handler {
+		return func(ctx context.Context, req any) (any, error) {
+			tr, ok := transport.FromServerContext(ctx)
+			if !ok {
+				return handler(ctx, req)
+			}
+
+			limiter, ok := limiters[tr.Operation()]
+			if !ok {
+				limiter = defaultLimiter
+			}
+
+			if limiter == nil {
+				return handler(ctx, req)
+			}
+
+			// Every store has its own budgets.
+			key := tenant.FromContext(ctx) + "|" + tr.Operation() + "|" + cfg.requestKey(ctx, tr, limiter.rule.Key)
+
+			isAllowed, state := limiter.allow(ctx, key)
+			setRateLimitHeaders(ctx, state, !isAllowed)
+
+			if !isAllowed {
+				return nil, ratelimit.ErrLimitExceed
+			}
+
+			return handler(ctx, req)
+		}
+	}, nil
+}
+
+func (cfg RateLimitConfig) requestKey(ctx context.Context, tr transport.Transporter, key RateLimitKey) string {
+	switch key {
+	case RateLimitByOperation:
+		return ""
+	case RateLimitByAPIKey:
+		if apiKey := tr.RequestHeader().Get(cfg.APIKeyHeader); apiKey != "" {
+			return "key:" + apiKey
+		}
+	case RateLimitByUser:
+		if user, ok := ctx.Value(userKey{}).(string); ok && user != "" {
+			return "user:" + user
+		}
+	case RateLimitByTenant:
+		if id := tenant.FromContext(ctx); id != "" {
+			return "tenant:" + id
+		}
+
+		if id := tr.RequestHeader().Get(cfg.TenantHeader); id != "" {
+			return "tenant:" + id
+		}
+	}
+
+	return "ip:" + cfg.clientIP(ctx, tr)
+}
+
+// clientIP returns the address of the HTTP client or of the gRPC peer.
+func (cfg RateLimitConfig) clientIP(ctx context.Context, tr transport.Transporter) string {
+	if cfg.TrustForwardedFor {
+		if forwarded := tr.RequestHeader().Get("X-Forwarded-For"); forwarded != "" {
+			addrs := strings.Split(forwarded, ",")
+
+			return strings.TrimSpace(addrs[len(addrs)-1])
+		}
+	}
+
+	var remoteAddr string
+
+	if req, ok := http.RequestFromServerContext(ctx); ok {
+		remoteAddr = req.RemoteAddr
+	} else if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		remoteAddr = p.Addr.String()
+	}
+
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		return remoteAddr
+	}
+
+	return host
+}
+
+// keyLimiter is a set of buckets of one rule.
+type keyLimiter interface {
+	allow(ctx context.Context, key string) (bool, RateLimitState)
+}
+
+type ruleLimiter struct {
+	rule RateLimitRule
+	keyLimiter
+}
+
+type tokenBucket struct {
+	key    string
+	tokens float64
+	last   time.Time
+}
+
+// keyedLimiter keeps a token bucket per key in an LRU list bounded by maxKeys.
+type keyedLimiter struct {
+	rule    RateLimitRule
+	burst   float64
+	maxKeys int
+
+	mu      sync.Mutex
+	buckets map[string]*list.Element
+	lru     *list.List
+}
+
+func newKeyedLimiter(rule RateLimitRule, maxKeys int) *keyedLimiter {
+	burst := float64(rule.Burst)
+	if burst <= 0 {
+		burst = rule.RequestsPerSecond
+	}
+
+	return &keyedLimiter{
+		rule:    rule,
+		burst:   max(burst, 1),
+		maxKeys: maxKeys,
+		buckets: make(map[string]*list.Element),
+		lru:     list.New(),
+	}
+}
+
+func (l *keyedLimiter) allow(_ context.Context, key string) (bool, RateLimitState) {
+	now := time.Now()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var bucket *tokenBucket
+
+	if elem, ok := l.buckets[key]; ok {
+		l.lru.MoveToFront(elem)
+
+		bucket = elem.Value.(*tokenBucket)
+		bucket.tokens = min(l.burst, bucket.tokens+now.Sub(bucket.last).Seconds()*l.rule.RequestsPerSecond)
+		bucket.last = now
+	} else {
+		// A dropped bucket is refilled anyway, so evicting the least recently used one
+		// only loses the debt of a client that has been quiet the longest.
+		if l.lru.Len() >= l.maxKeys {
+			oldest := l.lru.Back()
+			l.lru.Remove(oldest)
+			delete(l.buckets, oldest.Value.(*tokenBucket).key)
+		}
+
+		bucket = &tokenBucket{key: key, tokens: l.burst, last: now}
+		l.buckets[key] = l.lru.PushFront(bucket)
+	}
+
+	isAllowed := bucket.tokens >= 1
+	if isAllowed {
+		bucket.tokens--
+	}
+
+	return isAllowed, l.state(bucket.tokens)
+}
+
+func (l *keyedLimiter) state(tokens float64) RateLimitState {
+	perToken := float64(time.Second) / l.rule.RequestsPerSecond
+
+	return RateLimitState{
+		Limit:      int64(l.burst),
+		Remaining:  int64(tokens),
+		Reset:      time.Duration((l.burst - tokens) * perToken),
+		RetryAfter: time.Duration(max(1-tokens, 0) * perToken),
+	}
+}
+
+// sweep drops the buckets that have been idle long enough to be full until ctx is done.
+func (l *keyedLimiter) sweep(ctx context.Context) {
+	idle := time.Duration(l.burst / l.rule.RequestsPerSecond * float64(time.Second))
+
+	ticker := time.NewTicker(max(idle, time.Second))
+
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			l.mu.Lock()
+
+			for elem := l.lru.Back(); elem != nil; elem = l.lru.Back() {
+				bucket := elem.Value.(*tokenBucket)
+				if now.Sub(bucket.last) < idle {
+					break
+				}
+
+				l.lru.Remove(elem)
+				delete(l.buckets, bucket.key)
+			}
+
+			l.mu.Unlock()
+		}
+	}
+}
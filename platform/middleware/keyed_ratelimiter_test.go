@@ -0,0 +1,81 @@
+package middleware
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/go-kratos/kratos/v2/middleware/ratelimit"
+	"github.com/go-kratos/kratos/v2/transport"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/peer"
+)
+
+func TestKeyedRateLimiterAppliesOnlyRulesOfItsKeys(t *testing.T) {
+	const (
+		listOperation   = "/orders.v1.OrderService/ListOrders"
+		createOperation = "/orders.v1.OrderService/CreateOrder"
+	)
+
+	rules := []RateLimitRule{
+		{Key: RateLimitByIP, RequestsPerSecond: 0.001, Burst: 1},
+		{Operation: createOperation, Key: RateLimitByUser, RequestsPerSecond: 0.001, Burst: 1},
+	}
+
+	newLimiter := func(keys []RateLimitKey) func(operation string) error {
+		m, err := KeyedRateLimiter(t.Context(), RateLimitConfig{Rules: rules, Keys: keys, MaxKeys: 10})
+		require.NoError(t, err)
+
+		handler := m(func(context.Context, any) (any, error) { return nil, nil })
+
+		return func(operation string) error {
+			ctx := transport.NewServerContext(context.Background(), &testTransport{
+				operation: operation,
+				request:   testHeader{},
+				reply:     testHeader{},
+			})
+
+			_, err := handler(ContextWithUser(ctx, "alice"), nil)
+
+			return err
+		}
+	}
+
+	tests := []struct {
+		name          string
+		keys          []RateLimitKey
+		listLimited   bool
+		createLimited bool
+	}{
+		{"all keys", nil, true, true},
+		{"anonymous keys", AnonymousRateLimitKeys, true, false},
+		{"subject keys", SubjectRateLimitKeys, false, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			limit := newLimiter(tt.keys)
+
+			for operation, isLimited := range map[string]bool{listOperation: tt.listLimited, createOperation: tt.createLimited} {
+				require.NoError(t, limit(operation))
+
+				if isLimited {
+					assert.ErrorIs(t, limit(operation), ratelimit.ErrLimitExceed, operation)
+				} else {
+					assert.NoError(t, limit(operation), operation)
+				}
+			}
+		})
+	}
+}
+
+func TestClientIPOfGRPCPeer(t *testing.T) {
+	tr := &testTransport{request: testHeader{}, reply: testHeader{}}
+	ctx := peer.NewContext(context.Background(), &peer.Peer{
+		Addr: &net.TCPAddr{IP: net.ParseIP("10.0.0.7"), Port: 51234},
+	})
+
+	assert.Equal(t, "10.0.0.7", RateLimitConfig{}.clientIP(ctx, tr))
+	assert.Equal(t, "ip:10.0.0.7", RateLimitConfig{}.requestKey(ctx, tr, RateLimitByIP))
+}
@@ -0,0 +1,253 @@
+package middleware
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"sync"
+
+	"platform/logger"
+
+	"github.com/caarlos0/env/v11"
+	"github.com/go-kratos/aegis/ratelimit"
+	"github.com/go-kratos/aegis/ratelimit/bbr"
+	"github.com/go-kratos/kratos/v2/middleware"
+	kratosratelimit "github.com/go-kratos/kratos/v2/middleware/ratelimit"
+	"github.com/go-kratos/kratos/v2/transport"
+	"github.com/goccy/go-json"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Priority is the tier of a request, the lower tiers are shed first under load.
+type Priority string
+
+const (
+	PriorityCritical  Priority = "critical"
+	PriorityNormal    Priority = "normal"
+	PrioritySheddable Priority = "sheddable"
+)
+
+const (
+	shedReasonOverload  = "overload"
+	shedReasonRateLimit = "rate_limit"
+)
+
+// PriorityRule classifies the requests that match all of its non-empty conditions.
+type PriorityRule struct {
+	// Operation is the kratos operation, or its prefix if it ends with "*".
+	Operation string `json:"operation"`
+	// Header matches the requests whose header contains HeaderContains, case-insensitively.
+	Header         string `json:"header"`
+	HeaderContains string `json:"header_contains"`
+	// Role matches the requests with the role in their claims, see Auth.
+	Role     string   `json:"role"`
+	Priority Priority `json:"priority"`
+}
+
+// defaultPriorityRules apply after the configured ones: prefetches and crawlers are sheddable.
+var defaultPriorityRules = []PriorityRule{
+	{Header: "Sec-Purpose", HeaderContains: "prefetch", Priority: PrioritySheddable},
+	{Header: "Purpose", HeaderContains: "prefetch", Priority: PrioritySheddable},
+	{Header: "User-Agent", HeaderContains: "bot", Priority: PrioritySheddable},
+	{Header: "User-Agent", HeaderContains: "crawler", Priority: PrioritySheddable},
+	{Header: "User-Agent", HeaderContains: "spider", Priority: PrioritySheddable},
+}
+
+type priorityConfig struct {
+	Rules string `env:"LOAD_SHEDDING_PRIORITY_RULES" envDefault:"[]"`
+}
+
+type loadSheddingConfig struct {
+	// The thresholds are in per mille of CPU, like the one of bbr (800), which applies to PriorityCritical.
+	NormalCPUThreshold    int64 `env:"LOAD_SHEDDING_NORMAL_CPU_THRESHOLD" envDefault:"700"`
+	SheddableCPUThreshold int64 `env:"LOAD_SHEDDING_SHEDDABLE_CPU_THRESHOLD" envDefault:"500"`
+	// The ratios are the shares of the in-flight requests bbr estimates the server can handle.
+	NormalInFlightRatio    float64 `env:"LOAD_SHEDDING_NORMAL_IN_FLIGHT_RATIO" envDefault:"0.9"`
+	SheddableInFlightRatio float64 `env:"LOAD_SHEDDING_SHEDDABLE_IN_FLIGHT_RATIO" envDefault:"0.5"`
+}
+
+var shedRequests = sync.OnceValue(func() *prometheus.CounterVec {
+	requests := prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "http_shed_requests_total",
+			Help: "Number of requests rejected by the server limiters by priority and reason (overload, rate_limit)",
+		},
+		[]string{"priority", "reason"},
+	)
+
+	prometheus.MustRegister(requests)
+
+	return requests
+})
+
+type priorityKey struct{}
+
+// ContextWithPriority stores the priority of the request, see Prioritize.
+func ContextWithPriority(ctx context.Context, priority Priority) context.Context {
+	return context.WithValue(ctx, priorityKey{}, priority)
+}
+
+// PriorityFromContext returns the priority of the request, PriorityNormal if it is not classified.
+func PriorityFromContext(ctx context.Context) Priority {
+	if priority, ok := ctx.Value(priorityKey{}).(Priority); ok {
+		return priority
+	}
+
+	return PriorityNormal
+}
+
+// MustCreatePrioritize creates Prioritize with LOAD_SHEDDING_PRIORITY_RULES, a JSON array of PriorityRule,
+// e.g. [{"operation":"/checkout.v1.CheckoutService/*","priority":"critical"}], followed by the default rules.
+func MustCreatePrioritize() middleware.Middleware {
+	cfg, err := env.ParseAs[priorityConfig]()
+	if err != nil {
+		logger.Fatal(err.Error())
+
+		return nil
+	}
+
+	var rules []PriorityRule
+
+	if err = json.Unmarshal([]byte(cfg.Rules), &rules); err != nil {
+		logger.Fatal(errors.Wrap(err, "error occurred when unmarshalling priority rules").Error())
+
+		return nil
+	}
+
+	m, err := Prioritize(append(rules, defaultPriorityRules...))
+	if err != nil {
+		logger.Fatal(err.Error())
+
+		return nil
+	}
+
+	return m
+}
+
+// Prioritize classifies every request by the first matching rule, PriorityNormal if none matches,
+// for LoadShedding and the rejections of RateLimitServer. The rules with a role match only if it follows Auth.
+func Prioritize(rules []PriorityRule) (middleware.Middleware, error) {
+	for _, rule := range rules {
+		switch rule.Priority {
+		case PriorityCritical, PriorityNormal, PrioritySheddable:
+		default:
+			return nil, errors.Errorf("unknown priority: %s", rule.Priority)
+		}
+
+		if (rule.Header == "") != (rule.HeaderContains == "") {
+			return nil, errors.Errorf("priority rule of header %q must have both header and header_contains", rule.Header)
+		}
+	}
+
+	return func(handler middleware.Handler) middleware.Handler {
+		return func(ctx context.Context, req any) (any, error) {
+			tr, ok := transport.FromServerContext(ctx)
+			if !ok {
+				return handler(ctx, req)
+			}
+
+			for _, rule := range rules {
+				if rule.matches(ctx, tr) {
+					return handler(ContextWithPriority(ctx, rule.Priority), req)
+				}
+			}
+
+			return handler(ctx, req)
+		}
+	}, nil
+}
+
+func (r PriorityRule) matches(ctx context.Context, tr transport.Transporter) bool {
+	if prefix, isPrefix := strings.CutSuffix(r.Operation, "*"); isPrefix {
+		if !strings.HasPrefix(tr.Operation(), prefix) {
+			return false
+		}
+	} else if r.Operation != "" && r.Operation != tr.Operation() {
+		return false
+	}
+
+	if r.Header != "" &&
+		!strings.Contains(strings.ToLower(tr.RequestHeader().Get(r.Header)), strings.ToLower(r.HeaderContains)) {
+		return false
+	}
+
+	if r.Role != "" {
+		claims, ok := ClaimsFromContext(ctx)
+		if !ok || !slices.Contains(claims.Roles, r.Role) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// MustCreateLoadShedding sheds the requests by priority under load (see loadShedder), LOAD_SHEDDING_* variables
+// configure the tiers. It must follow Prioritize and precede the rate limiters, so a shed request takes no token.
+func MustCreateLoadShedding() middleware.Middleware {
+	shedder := mustCreateLoadShedder()
+
+	return func(handler middleware.Handler) middleware.Handler {
+		return func(ctx context.Context, req any) (any, error) {
+			done, err := shedder.allow(PriorityFromContext(ctx))
+			if err != nil {
+				return nil, kratosratelimit.ErrLimitExceed
+			}
+
+			reply, err := handler(ctx, req)
+			done(ratelimit.DoneInfo{Err: err})
+
+			return reply, err
+		}
+	}
+}
+
+// loadShedder is bbr with tiers: it rejects PrioritySheddable first, then PriorityNormal,
+// while the CPU usage and the in-flight requests grow. PriorityCritical is rejected only by bbr itself.
+type loadShedder struct {
+	bbr *bbr.BBR
+	cfg loadSheddingConfig
+}
+
+func mustCreateLoadShedder() *loadShedder {
+	cfg, err := env.ParseAs[loadSheddingConfig]()
+	if err != nil {
+		logger.Fatal(err.Error())
+
+		return nil
+	}
+
+	return &loadShedder{bbr: bbr.NewLimiter(), cfg: cfg}
+}
+
+func (s *loadShedder) allow(priority Priority) (ratelimit.DoneFunc, error) {
+	cpuThreshold, inFlightRatio := int64(0), 0.0
+
+	switch priority {
+	case PriorityNormal:
+		cpuThreshold, inFlightRatio = s.cfg.NormalCPUThreshold, s.cfg.NormalInFlightRatio
+	case PrioritySheddable:
+		cpuThreshold, inFlightRatio = s.cfg.SheddableCPUThreshold, s.cfg.SheddableInFlightRatio
+	}
+
+	if inFlightRatio > 0 {
+		stat := s.bbr.Stat()
+		if stat.CPU >= cpuThreshold && stat.InFlight > 1 &&
+			float64(stat.InFlight) > float64(stat.MaxInFlight)*inFlightRatio {
+			observeShed(priority, shedReasonOverload)
+
+			return nil, ratelimit.ErrLimitExceed
+		}
+	}
+
+	done, err := s.bbr.Allow()
+	if err != nil {
+		observeShed(priority, shedReasonOverload)
+	}
+
+	return done, err
+}
+
+func observeShed(priority Priority, reason string) {
+	shedRequests().WithLabelValues(string(priority), reason).Inc()
+}
@@ -1,17 +1,88 @@
 package middleware
 
 import (
+	"context"
+	"slices"
+	"strconv"
+	"sync"
+
+	"platform/logger"
 	"platform/metric"
+	"platform/tenant"
 
+	"github.com/caarlos0/env/v11"
+	kratoserrors "github.com/go-kratos/kratos/v2/errors"
 	"github.com/go-kratos/kratos/v2/middleware"
 	"github.com/go-kratos/kratos/v2/middleware/metrics"
+	"github.com/go-kratos/kratos/v2/transport"
+	"github.com/prometheus/client_golang/prometheus"
 )
 
+// otherTenant is the label of the tenants that are not in METRICS_TENANTS.
+const otherTenant = "other"
+
+type metricConfig struct {
+	// Tenants get their own series in server_tenant_requests_total, the others are counted as otherTenant,
+	// so a client cannot grow the cardinality with made-up tenants.
+	Tenants []string `env:"METRICS_TENANTS"`
+}
+
+var tenantRequests = sync.OnceValue(func() *prometheus.CounterVec {
+	requests := prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "server_tenant_requests_total",
+			Help: "Number of requests by tenant, operation and code",
+		},
+		[]string{"tenant", "operation", "code"},
+	)
+
+	prometheus.MustRegister(requests)
+
+	return requests
+})
+
+// MetricForServer is the kratos metrics.Server middleware that also counts the requests by tenant, see Tenant.
+// Only the tenants of METRICS_TENANTS are labeled by their ID.
 func MetricForServer(serviceName string) middleware.Middleware {
+	cfg, err := env.ParseAs[metricConfig]()
+	if err != nil {
+		logger.Fatal(err.Error())
+
+		return nil
+	}
+
 	metricRequests, metricSeconds := metric.NewMetric(serviceName)
+	byTenant := tenantRequests()
+
+	return middleware.Chain(
+		metrics.Server(
+			metrics.WithSeconds(metricSeconds),
+			metrics.WithRequests(metricRequests),
+		),
+		func(handler middleware.Handler) middleware.Handler {
+			return func(ctx context.Context, req any) (any, error) {
+				reply, err := handler(ctx, req)
+
+				if tr, ok := transport.FromServerContext(ctx); ok {
+					code := 200
+					if err != nil {
+						code = int(kratoserrors.FromError(err).Code)
+					}
 
-	return metrics.Server(
-		metrics.WithSeconds(metricSeconds),
-		metrics.WithRequests(metricRequests),
+					byTenant.WithLabelValues(tenantLabel(cfg.Tenants, tenant.FromContext(ctx)), tr.Operation(), strconv.Itoa(code)).Inc()
+				}
+
+				return reply, err
+			}
+		},
 	)
 }
+
+// tenantLabel returns the tenant if it is known, empty if there is none and otherTenant otherwise.
+func tenantLabel(known []string, id string) string {
+	if id == "" || slices.Contains(known, id) {
+		return id
+	}
+
+	return otherTenant
+}
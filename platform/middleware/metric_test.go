@@ -0,0 +1,16 @@
+package middleware
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTenantLabelCountsUnknownTenantsAsOther(t *testing.T) {
+	known := []string{"acme", "globex"}
+
+	assert.Equal(t, "acme", tenantLabel(known, "acme"))
+	assert.Equal(t, "", tenantLabel(known, ""))
+	assert.Equal(t, otherTenant, tenantLabel(known, "made-up-42"))
+	assert.Equal(t, otherTenant, tenantLabel(nil, "acme"))
+}
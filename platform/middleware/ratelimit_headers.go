@@ -0,0 +1,113 @@
+package middleware
+
+import (
+	"context"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/go-kratos/aegis/ratelimit"
+	"github.com/go-kratos/kratos/v2/middleware"
+	kratosratelimit "github.com/go-kratos/kratos/v2/middleware/ratelimit"
+	"github.com/go-kratos/kratos/v2/transport"
+)
+
+const (
+	headerRateLimitLimit     = "RateLimit-Limit"
+	headerRateLimitRemaining = "RateLimit-Remaining"
+	headerRateLimitReset     = "RateLimit-Reset"
+	headerRetryAfter         = "Retry-After"
+)
+
+// RateLimitState is the state of a bucket after a request, it is reported in the RateLimit headers.
+type RateLimitState struct {
+	Limit     int64
+	Remaining int64
+	// Reset is the time until the bucket is full again.
+	Reset time.Duration
+	// RetryAfter is the time until the next request can be allowed.
+	RetryAfter time.Duration
+}
+
+// StatefulLimiter is a ratelimit.Limiter that reports its state, see RateLimitServer.
+type StatefulLimiter interface {
+	ratelimit.Limiter
+	AllowWithState() (ratelimit.DoneFunc, RateLimitState, error)
+}
+
+// PriorityLimiter is a StatefulLimiter that reports its rejections by the priority of the request, see Prioritize.
+// AllowWithState allows a request of PriorityNormal.
+type PriorityLimiter interface {
+	StatefulLimiter
+	AllowWithPriority(priority Priority) (ratelimit.DoneFunc, RateLimitState, error)
+}
+
+// RateLimitServer is the kratos ratelimit.Server middleware that also sets the RateLimit-Limit,
+// RateLimit-Remaining and RateLimit-Reset headers, and Retry-After on rejection, for a StatefulLimiter.
+// A PriorityLimiter gets the priority of the request.
+func RateLimitServer(limiter ratelimit.Limiter) middleware.Middleware {
+	stateful, isStateful := limiter.(StatefulLimiter)
+	prioritized, isPrioritized := limiter.(PriorityLimiter)
+
+	return func(handler middleware.Handler) middleware.Handler {
+		return func(ctx context.Context, req any) (any, error) {
+			var (
+				done ratelimit.DoneFunc
+				err  error
+			)
+
+			switch {
+			case isPrioritized:
+				var state RateLimitState
+
+				done, state, err = prioritized.AllowWithPriority(PriorityFromContext(ctx))
+				setRateLimitHeaders(ctx, state, err != nil)
+			case isStateful:
+				var state RateLimitState
+
+				done, state, err = stateful.AllowWithState()
+				setRateLimitHeaders(ctx, state, err != nil)
+			default:
+				done, err = limiter.Allow()
+			}
+
+			if err != nil {
+				return nil, kratosratelimit.ErrLimitExceed
+			}
+
+			reply, err := handler(ctx, req)
+			done(ratelimit.DoneInfo{Err: err})
+
+			return reply, err
+		}
+	}
+}
+
+// setRateLimitHeaders reports the state in the reply headers. Several limiters can run for one request,
+// so an allowed state is reported only if it is more restrictive than the one already reported.
+func setRateLimitHeaders(ctx context.Context, state RateLimitState, isRejected bool) {
+	tr, ok := transport.FromServerContext(ctx)
+	if !ok || state.Limit == 0 {
+		return
+	}
+
+	header := tr.ReplyHeader()
+
+	if prev := header.Get(headerRateLimitRemaining); prev != "" && !isRejected {
+		if remaining, err := strconv.ParseInt(prev, 10, 64); err == nil && remaining <= state.Remaining {
+			return
+		}
+	}
+
+	header.Set(headerRateLimitLimit, strconv.FormatInt(state.Limit, 10))
+	header.Set(headerRateLimitRemaining, strconv.FormatInt(max(state.Remaining, 0), 10))
+	header.Set(headerRateLimitReset, strconv.FormatInt(ceilSeconds(state.Reset), 10))
+
+	if isRejected {
+		header.Set(headerRetryAfter, strconv.FormatInt(max(ceilSeconds(state.RetryAfter), 1), 10))
+	}
+}
+
+func ceilSeconds(d time.Duration) int64 {
+	return int64(math.Ceil(d.Seconds()))
+}
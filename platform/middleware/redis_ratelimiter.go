@@ -0,0 +1,183 @@
+package middleware
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"platform/logger"
+
+	"github.com/go-kratos/aegis/ratelimit"
+	"github.com/pkg/errors"
+	"github.com/redis/rueidis"
+)
+
+const (
+	redisRateLimitPrefix = "ratelimit:"
+	// redisRateLimitBackoff is how long the local fallback is used after a redis error.
+	redisRateLimitBackoff = time.Second
+	redisRateLimitTimeout = 50 * time.Millisecond
+)
+
+// gcraScript is the generic cell rate algorithm: the key holds the theoretical arrival time (TAT)
+// in microseconds of the redis clock, a request is allowed if the new TAT is within the burst tolerance.
+// ARGV[1] is the emission interval, ARGV[2] is the tolerance, both in microseconds.
+// It returns whether the request is allowed, the remaining requests, and the reset and retry after delays.
+var gcraScript = rueidis.NewLuaScript(`
+local now = redis.call('TIME')
+now = tonumber(now[1]) * 1000000 + tonumber(now[2])
+
+local interval = tonumber(ARGV[1])
+local tolerance = tonumber(ARGV[2])
+
+local tat = tonumber(redis.call('GET', KEYS[1]) or now)
+if tat < now then
+	tat = now
+end
+
+local newTat = tat + interval
+local allowAt = newTat - tolerance
+if allowAt > now then
+	return {0, 0, tat - now, allowAt - now}
+end
+
+redis.call('SET', KEYS[1], newTat, 'PX', math.max(1, math.ceil((newTat - now) / 1000)))
+
+return {1, math.floor((now - allowAt) / interval), newTat - now, 0}
+`)
+
+// redisBucket is a GCRA bucket shared by every replica through redis.
+type redisBucket struct {
+	client    rueidis.Client
+	interval  int64
+	tolerance int64
+	limit     int64
+	downUntil atomic.Int64
+}
+
+func newRedisBucket(client rueidis.Client, requestsPerSecond float64, burst float64) *redisBucket {
+	interval := int64(float64(time.Second/time.Microsecond) / requestsPerSecond)
+
+	return &redisBucket{
+		client:    client,
+		interval:  interval,
+		tolerance: int64(float64(interval) * burst),
+		limit:     int64(burst),
+	}
+}
+
+// allow returns whether the request is allowed and false in ok if redis can not be used.
+func (b *redisBucket) allow(ctx context.Context, key string) (isAllowed bool, state RateLimitState, ok bool) {
+	if time.Now().UnixNano() < b.downUntil.Load() {
+		return false, RateLimitState{}, false
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, redisRateLimitTimeout)
+	defer cancel()
+
+	// The key is hashed, so API keys are not stored in redis.
+	hash := sha256.Sum256([]byte(key))
+
+	res, err := gcraScript.Exec(
+		ctx,
+		b.client,
+		[]string{redisRateLimitPrefix + hex.EncodeToString(hash[:16])},
+		[]string{strconv.FormatInt(b.interval, 10), strconv.FormatInt(b.tolerance, 10)},
+	).AsIntSlice()
+	if err == nil && len(res) != 4 {
+		err = errors.Errorf("unexpected rate limit script result: %v", res)
+	}
+
+	if err != nil {
+		// Only the first error of a backoff is logged, so a redis outage does not flood the log.
+		if b.downUntil.Swap(time.Now().Add(redisRateLimitBackoff).UnixNano()) < time.Now().UnixNano() {
+			logger.Errorf("[Redis] error occurred when rate limiting, using the local limiter: %v", err)
+		}
+
+		return false, RateLimitState{}, false
+	}
+
+	return res[0] == 1, RateLimitState{
+		Limit:      b.limit,
+		Remaining:  res[1],
+		Reset:      time.Duration(res[2]) * time.Microsecond,
+		RetryAfter: time.Duration(res[3]) * time.Microsecond,
+	}, true
+}
+
+// redisKeyLimiter shares the buckets of a rule between the replicas and falls back to local buckets.
+type redisKeyLimiter struct {
+	bucket   *redisBucket
+	fallback keyLimiter
+}
+
+func newRedisKeyLimiter(client rueidis.Client, rule RateLimitRule, fallback *keyedLimiter) *redisKeyLimiter {
+	return &redisKeyLimiter{
+		bucket:   newRedisBucket(client, rule.RequestsPerSecond, fallback.burst),
+		fallback: fallback,
+	}
+}
+
+func (l *redisKeyLimiter) allow(ctx context.Context, key string) (bool, RateLimitState) {
+	if isAllowed, state, ok := l.bucket.allow(ctx, key); ok {
+		return isAllowed, state
+	}
+
+	return l.fallback.allow(ctx, key)
+}
+
+type redisRateLimiter struct {
+	ctx      context.Context
+	name     string
+	bucket   *redisBucket
+	fallback PriorityLimiter
+}
+
+var _ PriorityLimiter = (*redisRateLimiter)(nil)
+
+// RedisRateLimiter is ServerRateLimiter shared by every replica that uses the same name:
+// the whole cluster gets requestPerSecondLimit. When redis is unreachable every replica
+// falls back to its own ServerRateLimiter with the same limit.
+func RedisRateLimiter(
+	ctx context.Context,
+	client rueidis.Client,
+	name string,
+	requestPerSecondLimit uint64,
+) PriorityLimiter {
+	limit := float64(requestPerSecondLimit)
+
+	return &redisRateLimiter{
+		ctx:      ctx,
+		name:     name,
+		bucket:   newRedisBucket(client, limit, limit),
+		fallback: ServerRateLimiter(ctx, requestPerSecondLimit),
+	}
+}
+
+func (s *redisRateLimiter) Allow() (ratelimit.DoneFunc, error) {
+	done, _, err := s.AllowWithState()
+
+	return done, err
+}
+
+func (s *redisRateLimiter) AllowWithState() (ratelimit.DoneFunc, RateLimitState, error) {
+	return s.AllowWithPriority(PriorityNormal)
+}
+
+func (s *redisRateLimiter) AllowWithPriority(priority Priority) (ratelimit.DoneFunc, RateLimitState, error) {
+	isAllowed, state, ok := s.bucket.allow(s.ctx, s.name)
+	if !ok {
+		return s.fallback.AllowWithPriority(priority)
+	}
+
+	if !isAllowed {
+		observeShed(priority, shedReasonRateLimit)
+
+		return nil, state, ratelimit.ErrLimitExceed
+	}
+
+	return func(ratelimit.DoneInfo) {}, state, nil
+}
@@ -0,0 +1,47 @@
+package middleware
+
+import (
+	"context"
+
+	"platform/requestid"
+
+	"github.com/go-kratos/kratos/v2/middleware"
+	"github.com/go-kratos/kratos/v2/transport"
+)
+
+// RequestID accepts the X-Request-ID of the request or generates one, puts it into the context
+// and the reply headers. It must precede the logging middleware, so its lines have request.id.
+func RequestID() middleware.Middleware {
+	return func(handler middleware.Handler) middleware.Handler {
+		return func(ctx context.Context, req any) (any, error) {
+			tr, ok := transport.FromServerContext(ctx)
+			if !ok {
+				return handler(ctx, req)
+			}
+
+			id := tr.RequestHeader().Get(requestid.Header)
+			if !requestid.IsValid(id) {
+				id = requestid.New()
+			}
+
+			tr.ReplyHeader().Set(requestid.Header, id)
+
+			return handler(requestid.NewContext(ctx, id), req)
+		}
+	}
+}
+
+// RequestIDClient forwards the request ID of the context in the X-Request-ID of outgoing HTTP and gRPC calls.
+func RequestIDClient() middleware.Middleware {
+	return func(handler middleware.Handler) middleware.Handler {
+		return func(ctx context.Context, req any) (any, error) {
+			if id := requestid.FromContext(ctx); id != "" {
+				if tr, ok := transport.FromClientContext(ctx); ok {
+					tr.RequestHeader().Set(requestid.Header, id)
+				}
+			}
+
+			return handler(ctx, req)
+		}
+	}
+}
@@ -0,0 +1,275 @@
+package middleware
+
+import (
+	"context"
+	"maps"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"platform/cache"
+	"platform/logger"
+	"platform/tenant"
+
+	"github.com/caarlos0/env/v11"
+	"github.com/go-kratos/kratos/v2/middleware"
+	"github.com/go-kratos/kratos/v2/transport"
+	khttp "github.com/go-kratos/kratos/v2/transport/http"
+	"github.com/goccy/go-json"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+)
+
+const (
+	responseCacheTable = "response_cache"
+	headerETag         = "ETag"
+	headerCacheControl = "Cache-Control"
+	headerIfNoneMatch  = "If-None-Match"
+	headerVary         = "Vary"
+)
+
+// ResponseCachePolicy makes an operation cacheable for anonymous safe requests.
+type ResponseCachePolicy struct {
+	MaxAge time.Duration
+	// VaryHeaders are the request headers that are part of the cache key, Accept always is.
+	VaryHeaders []string
+}
+
+type responseCachePolicyConfig struct {
+	MaxAgeSeconds uint32   `json:"max_age_seconds"`
+	VaryHeaders   []string `json:"vary_headers"`
+}
+
+type responseCacheConfig struct {
+	Policies string `env:"RESPONSE_CACHE_POLICIES" envDefault:"{}"`
+}
+
+// cachedResponse is a reply stored by ResponseCache.
+type cachedResponse struct {
+	ReplyType string `json:"reply_type"`
+	Reply     []byte `json:"reply"`
+	ETag      string `json:"etag"`
+}
+
+var responseCacheRequests = sync.OnceValue(func() *prometheus.CounterVec {
+	requests := prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "http_response_cache_requests_total",
+			Help: "Number of cacheable requests by operation and result (hit, miss)",
+		},
+		[]string{"operation", "result"},
+	)
+
+	prometheus.MustRegister(requests)
+
+	return requests
+})
+
+// MustCreateResponseCache creates ResponseCache with the policies and the ones of RESPONSE_CACHE_POLICIES,
+// a JSON object by operation, e.g. {"/catalog.v1.CatalogService/ListProducts": {"max_age_seconds": 60}}.
+func MustCreateResponseCache(c cache.Cache, policies map[string]ResponseCachePolicy) middleware.Middleware {
+	cfg, err := env.ParseAs[responseCacheConfig]()
+	if err != nil {
+		logger.Fatal(err.Error())
+
+		return nil
+	}
+
+	tenantCfg, err := env.ParseAs[TenantConfig]()
+	if err != nil {
+		logger.Fatal(err.Error())
+
+		return nil
+	}
+
+	var configured map[string]responseCachePolicyConfig
+
+	if err = json.Unmarshal([]byte(cfg.Policies), &configured); err != nil {
+		logger.Fatal(errors.Wrap(err, "error occurred when unmarshalling response cache policies").Error())
+
+		return nil
+	}
+
+	merged := maps.Clone(policies)
+	if merged == nil {
+		merged = make(map[string]ResponseCachePolicy, len(configured))
+	}
+
+	for operation, policy := range configured {
+		merged[operation] = ResponseCachePolicy{
+			MaxAge:      time.Duration(policy.MaxAgeSeconds) * time.Second,
+			VaryHeaders: policy.VaryHeaders,
+		}
+	}
+
+	return ResponseCache(c, merged, tenantCfg.requestHeaders()...)
+}
+
+// ResponseCache caches the proto replies of the operations with a policy for anonymous GET requests,
+// keyed by operation, request and the vary headers. It sets ETag and Cache-Control, the server must use
+// ResponseEncoder to answer If-None-Match with 304.
+// The tenant headers are the request headers the tenant comes from (see TenantConfig), they are added to Vary
+// so the shared caches keep the stores apart; the response of a tenant is private if there are none.
+func ResponseCache(c cache.Cache, policies map[string]ResponseCachePolicy, tenantHeaders ...string) middleware.Middleware {
+	requests := responseCacheRequests()
+
+	return func(handler middleware.Handler) middleware.Handler {
+		return func(ctx context.Context, req any) (any, error) {
+			tr, ok := transport.FromServerContext(ctx)
+			if !ok {
+				return handler(ctx, req)
+			}
+
+			policy, ok := policies[tr.Operation()]
+			if !ok || policy.MaxAge <= 0 {
+				return handler(ctx, req)
+			}
+
+			httpTr, ok := tr.(khttp.Transporter)
+			if !ok || httpTr.Request().Method != http.MethodGet || tr.RequestHeader().Get("Authorization") != "" {
+				return handler(ctx, req)
+			}
+
+			key, err := responseCacheKey(tr, policy, req)
+			if err != nil {
+				return handler(ctx, req)
+			}
+
+			response, reply := cachedReply(ctx, c, key)
+			if response != nil {
+				requests.WithLabelValues(tr.Operation(), "hit").Inc()
+			} else {
+				requests.WithLabelValues(tr.Operation(), "miss").Inc()
+
+				reply, err = handler(ctx, req)
+				if err != nil {
+					return reply, err
+				}
+
+				var isCacheable bool
+
+				response, isCacheable = newCachedResponse(reply, tr.RequestHeader().Get("Accept"))
+				if !isCacheable {
+					return reply, nil
+				}
+
+				data, _ := json.Marshal(response)
+				if err = c.SetBytesWithTTL(ctx, responseCacheTable, key, data, policy.MaxAge); err != nil {
+					logger.Errorf("error occurred when caching a response: %v", err)
+				}
+			}
+
+			tr.ReplyHeader().Set(headerETag, response.ETag)
+			vary := append(append([]string{"Accept"}, policy.VaryHeaders...), tenantHeaders...)
+
+			visibility := "public"
+			if len(tenantHeaders) == 0 && tenant.FromContext(ctx) != "" {
+				visibility = "private"
+			}
+
+			tr.ReplyHeader().Set(headerCacheControl, visibility+", max-age="+strconv.Itoa(int(policy.MaxAge.Seconds())))
+			tr.ReplyHeader().Add(headerVary, strings.Join(vary, ", "))
+
+			return reply, nil
+		}
+	}
+}
+
+// ResponseEncoder is khttp.DefaultResponseEncoder that answers with 304 Not Modified
+// when If-None-Match matches the ETag set by ResponseCache.
+func ResponseEncoder(w http.ResponseWriter, r *http.Request, v any) error {
+	if etag := w.Header().Get(headerETag); etag != "" && etagMatches(r.Header.Get(headerIfNoneMatch), etag) {
+		w.WriteHeader(http.StatusNotModified)
+
+		// The writer of kratos sends the status with the first write.
+		_, err := w.Write(nil)
+
+		return err
+	}
+
+	return khttp.DefaultResponseEncoder(w, r, v)
+}
+
+// cachedReply returns the cached response of the key and its decoded reply, nil if there is none.
+func cachedReply(ctx context.Context, c cache.Cache, key string) (*cachedResponse, any) {
+	data, isExist := c.GetBytes(ctx, responseCacheTable, key)
+	if !isExist {
+		return nil, nil
+	}
+
+	var response cachedResponse
+
+	if err := json.Unmarshal(data, &response); err != nil {
+		logger.Errorf("error occurred when unmarshalling a cached response: %v", err)
+
+		return nil, nil
+	}
+
+	msgType, err := protoregistry.GlobalTypes.FindMessageByName(protoreflect.FullName(response.ReplyType))
+	if err != nil {
+		logger.Errorf("error occurred when finding a cached reply type: %v", err)
+
+		return nil, nil
+	}
+
+	reply := msgType.New().Interface()
+	if err = proto.Unmarshal(response.Reply, reply); err != nil {
+		logger.Errorf("error occurred when unmarshalling a cached reply: %v", err)
+
+		return nil, nil
+	}
+
+	return &response, reply
+}
+
+func responseCacheKey(tr transport.Transporter, policy ResponseCachePolicy, req any) (string, error) {
+	fingerprint, err := requestFingerprint(req)
+	if err != nil {
+		return "", err
+	}
+
+	parts := []string{tr.Operation(), fingerprint, tr.RequestHeader().Get("Accept")}
+	for _, header := range policy.VaryHeaders {
+		parts = append(parts, tr.RequestHeader().Get(header))
+	}
+
+	return hashParts(parts...), nil
+}
+
+// newCachedResponse returns the response to cache or false if the reply is not a proto message.
+// The ETag depends on Accept, which is part of the cache key, as the representation does.
+func newCachedResponse(reply any, accept string) (*cachedResponse, bool) {
+	msg, ok := reply.(proto.Message)
+	if !ok {
+		return nil, false
+	}
+
+	data, err := proto.MarshalOptions{Deterministic: true}.Marshal(msg)
+	if err != nil {
+		return nil, false
+	}
+
+	return &cachedResponse{
+		ReplyType: string(msg.ProtoReflect().Descriptor().FullName()),
+		Reply:     data,
+		ETag:      `"` + hashParts(accept, string(data))[:32] + `"`,
+	}, true
+}
+
+func etagMatches(ifNoneMatch string, etag string) bool {
+	if ifNoneMatch == "" {
+		return false
+	}
+
+	return slices.ContainsFunc(strings.Split(ifNoneMatch, ","), func(candidate string) bool {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+
+		return candidate == "*" || candidate == etag
+	})
+}
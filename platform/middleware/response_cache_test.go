@@ -0,0 +1,204 @@
+package middleware
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"platform/cache"
+
+	"github.com/go-kratos/kratos/v2/middleware"
+	khttp "github.com/go-kratos/kratos/v2/transport/http"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+const testGreetOperation = "/greeter.v1.GreeterService/Greet"
+
+type mapCache struct {
+	cache.Cache
+
+	mu     sync.Mutex
+	values map[string][]byte
+}
+
+func (c *mapCache) GetBytes(_ context.Context, table string, key string) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	value, ok := c.values[table+":"+key]
+
+	return value, ok
+}
+
+func (c *mapCache) SetBytesWithTTL(_ context.Context, table string, key string, value []byte, _ time.Duration) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.values[table+":"+key] = value
+
+	return nil
+}
+
+func (c *mapCache) SetBytesIfNotExists(
+	_ context.Context,
+	table string,
+	key string,
+	value []byte,
+	_ time.Duration,
+) (bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.values[table+":"+key]; ok {
+		return false, nil
+	}
+
+	c.values[table+":"+key] = value
+
+	return true, nil
+}
+
+func (c *mapCache) Delete(_ context.Context, table string, key string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.values, table+":"+key)
+
+	return nil
+}
+
+// newGreeterServer serves GET /greeting like a handler generated by protoc-gen-go-http.
+func newGreeterServer(t *testing.T, calls *atomic.Int32, m ...middleware.Middleware) *httptest.Server {
+	t.Helper()
+
+	if len(m) == 0 {
+		m = append(m, ResponseCache(
+			&mapCache{values: make(map[string][]byte)},
+			map[string]ResponseCachePolicy{testGreetOperation: {MaxAge: time.Minute}},
+		))
+	}
+
+	srv := khttp.NewServer(
+		khttp.Middleware(m...),
+		khttp.ResponseEncoder(ResponseEncoder),
+	)
+
+	srv.Route("/").GET("/greeting", func(ctx khttp.Context) error {
+		var in wrapperspb.StringValue
+		if err := ctx.BindQuery(&in); err != nil {
+			return err
+		}
+
+		khttp.SetOperation(ctx, testGreetOperation)
+
+		h := ctx.Middleware(func(_ context.Context, req any) (any, error) {
+			calls.Add(1)
+
+			return wrapperspb.String("hello " + req.(*wrapperspb.StringValue).GetValue()), nil
+		})
+
+		out, err := h(ctx, &in)
+		if err != nil {
+			return err
+		}
+
+		reply := out.(*wrapperspb.StringValue)
+
+		return ctx.Result(http.StatusOK, reply)
+	})
+
+	server := httptest.NewServer(srv)
+	t.Cleanup(server.Close)
+
+	return server
+}
+
+func getGreeting(t *testing.T, url string, ifNoneMatch string, headers ...string) (*http.Response, string) {
+	t.Helper()
+
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, url+"/greeting?value=world", nil)
+	require.NoError(t, err)
+
+	if ifNoneMatch != "" {
+		req.Header.Set(headerIfNoneMatch, ifNoneMatch)
+	}
+
+	for i := 0; i+1 < len(headers); i += 2 {
+		req.Header.Set(headers[i], headers[i+1])
+	}
+
+	res, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+
+	defer res.Body.Close()
+
+	body, err := io.ReadAll(res.Body)
+	require.NoError(t, err)
+
+	return res, string(body)
+}
+
+func TestResponseCacheServesCachedReplies(t *testing.T) {
+	var calls atomic.Int32
+
+	server := newGreeterServer(t, &calls)
+
+	miss, missBody := getGreeting(t, server.URL, "")
+	require.Equal(t, http.StatusOK, miss.StatusCode)
+	assert.JSONEq(t, `"hello world"`, missBody)
+	assert.Equal(t, "public, max-age=60", miss.Header.Get(headerCacheControl))
+
+	etag := miss.Header.Get(headerETag)
+	require.NotEmpty(t, etag)
+
+	hit, hitBody := getGreeting(t, server.URL, "")
+	require.Equal(t, http.StatusOK, hit.StatusCode)
+	assert.Equal(t, missBody, hitBody)
+	assert.Equal(t, etag, hit.Header.Get(headerETag))
+
+	notModified, notModifiedBody := getGreeting(t, server.URL, etag)
+	assert.Equal(t, http.StatusNotModified, notModified.StatusCode)
+	assert.Empty(t, notModifiedBody)
+	assert.Equal(t, etag, notModified.Header.Get(headerETag))
+
+	assert.Equal(t, int32(1), calls.Load())
+}
+
+func TestResponseCacheKeepsTenantsApartInSharedCaches(t *testing.T) {
+	tenantMiddleware, err := Tenant(TenantConfig{Sources: []TenantSource{TenantFromHeader}, Header: "X-Tenant-ID"})
+	require.NoError(t, err)
+
+	policies := map[string]ResponseCachePolicy{testGreetOperation: {MaxAge: time.Minute}}
+
+	tests := []struct {
+		name          string
+		tenantHeaders []string
+		cacheControl  string
+		vary          string
+	}{
+		{"tenant header", []string{"X-Tenant-ID"}, "public, max-age=60", "Accept, X-Tenant-ID"},
+		{"unknown tenant source", nil, "private, max-age=60", "Accept"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls atomic.Int32
+
+			server := newGreeterServer(t, &calls, tenantMiddleware, ResponseCache(
+				&mapCache{values: make(map[string][]byte)}, policies, tt.tenantHeaders...,
+			))
+
+			res, _ := getGreeting(t, server.URL, "", "X-Tenant-ID", "acme")
+			require.Equal(t, http.StatusOK, res.StatusCode)
+			assert.Equal(t, tt.cacheControl, res.Header.Get(headerCacheControl))
+			assert.Equal(t, tt.vary, res.Header.Get(headerVary))
+		})
+	}
+}
@@ -7,18 +7,19 @@ import (
 	"time"
 
 	"github.com/go-kratos/aegis/ratelimit"
-	"github.com/go-kratos/aegis/ratelimit/bbr"
 )
 
 type serverRateLimiter struct {
-	bbr                   *bbr.BBR
 	requestsLeft          atomic.Int64
 	requestPerSecondLimit int64
+	refillPeriod          time.Duration
 }
 
-var _ ratelimit.Limiter = (*serverRateLimiter)(nil)
+var _ PriorityLimiter = (*serverRateLimiter)(nil)
 
-func ServerRateLimiter(ctx context.Context, requestPerSecondLimit uint64) ratelimit.Limiter {
+// ServerRateLimiter limits the requests of the server by a token bucket.
+// LoadShedding must precede it, so the requests shed under load take no token.
+func ServerRateLimiter(ctx context.Context, requestPerSecondLimit uint64) PriorityLimiter {
 	const Frequency = 10
 
 	if requestPerSecondLimit < Frequency {
@@ -28,15 +29,15 @@ func ServerRateLimiter(ctx context.Context, requestPerSecondLimit uint64) rateli
 	limit := int64(requestPerSecondLimit)
 
 	s := &serverRateLimiter{
-		bbr:                   bbr.NewLimiter(),
 		requestsLeft:          atomic.Int64{},
 		requestPerSecondLimit: limit,
+		refillPeriod:          time.Second / Frequency,
 	}
 
 	s.requestsLeft.Store(limit)
 
 	go func() {
-		ticker := time.NewTicker(time.Second / Frequency)
+		ticker := time.NewTicker(s.refillPeriod)
 
 		defer ticker.Stop()
 
@@ -64,6 +65,16 @@ func ServerRateLimiter(ctx context.Context, requestPerSecondLimit uint64) rateli
 }
 
 func (s *serverRateLimiter) Allow() (ratelimit.DoneFunc, error) {
+	done, _, err := s.AllowWithState()
+
+	return done, err
+}
+
+func (s *serverRateLimiter) AllowWithState() (ratelimit.DoneFunc, RateLimitState, error) {
+	return s.AllowWithPriority(PriorityNormal)
+}
+
+func (s *serverRateLimiter) AllowWithPriority(priority Priority) (ratelimit.DoneFunc, RateLimitState, error) {
 	curr := s.requestsLeft.Add(-1)
 	if curr < 0 {
 		// It is a hot-path optimization: we believe that it is unlikely to exceed the limit,
@@ -71,15 +82,28 @@ func (s *serverRateLimiter) Allow() (ratelimit.DoneFunc, error) {
 
 		s.requestsLeft.Add(1)
 
-		return nil, ratelimit.ErrLimitExceed
+		observeShed(priority, shedReasonRateLimit)
+
+		return nil, s.state(0), ratelimit.ErrLimitExceed
+	}
+
+	return func(ratelimit.DoneInfo) {}, s.state(curr), nil
+}
+
+// state is computed from the refilling loop: every refillPeriod adds a tenth of the limit.
+func (s *serverRateLimiter) state(remaining int64) RateLimitState {
+	perPeriod := s.requestPerSecondLimit * int64(s.refillPeriod) / int64(time.Second)
+	periods := (s.requestPerSecondLimit - remaining + perPeriod - 1) / perPeriod
+
+	state := RateLimitState{
+		Limit:     s.requestPerSecondLimit,
+		Remaining: remaining,
+		Reset:     time.Duration(periods) * s.refillPeriod,
 	}
 
-	bbrFunc, err := s.bbr.Allow()
-	if err != nil {
-		return nil, err
+	if remaining == 0 {
+		state.RetryAfter = s.refillPeriod
 	}
 
-	return func(info ratelimit.DoneInfo) {
-		bbrFunc(info)
-	}, nil
+	return state
 }
@@ -0,0 +1,179 @@
+package middleware
+
+import (
+	"context"
+	"net"
+	"strings"
+
+	"platform/logger"
+	"platform/tenant"
+
+	"github.com/caarlos0/env/v11"
+	kratoserrors "github.com/go-kratos/kratos/v2/errors"
+	"github.com/go-kratos/kratos/v2/middleware"
+	"github.com/go-kratos/kratos/v2/transport"
+	khttp "github.com/go-kratos/kratos/v2/transport/http"
+	"github.com/pkg/errors"
+)
+
+type TenantSource string
+
+const (
+	// TenantFromClaim reads the tenant_id claim of the token, see Claims.
+	TenantFromClaim TenantSource = "claim"
+	// TenantFromHost reads the subdomain of the host under TenantConfig.HostSuffix.
+	TenantFromHost   TenantSource = "host"
+	TenantFromHeader TenantSource = "header"
+)
+
+var (
+	ErrTenantRequired = kratoserrors.BadRequest("TENANT_REQUIRED", "the request does not identify a store")
+	ErrInvalidTenant  = kratoserrors.BadRequest("INVALID_TENANT", "the store of the request is invalid")
+	ErrTenantMismatch = kratoserrors.Forbidden("TENANT_MISMATCH", "the token is issued for another store")
+)
+
+type TenantConfig struct {
+	// Sources are tried in order, the first tenant found is used.
+	Sources []TenantSource `env:"TENANT_SOURCES" envDefault:"claim,host,header"`
+	// HostSuffix is the domain of the stores, e.g. shop.example.com for acme.shop.example.com.
+	HostSuffix string `env:"TENANT_HOST_SUFFIX"`
+	Header     string `env:"TENANT_HEADER" envDefault:"X-Tenant-ID"`
+	// Required rejects the requests without a tenant with ErrTenantRequired.
+	Required bool `env:"TENANT_REQUIRED"`
+}
+
+// MustCreateTenant creates Tenant from TENANT_SOURCES, TENANT_HOST_SUFFIX, TENANT_HEADER and TENANT_REQUIRED.
+func MustCreateTenant() middleware.Middleware {
+	cfg, err := env.ParseAs[TenantConfig]()
+	if err != nil {
+		logger.Fatal(err.Error())
+
+		return nil
+	}
+
+	m, err := Tenant(cfg)
+	if err != nil {
+		logger.Fatal(err.Error())
+
+		return nil
+	}
+
+	return m
+}
+
+// Tenant resolves the tenant of the request and puts it into the context (see tenant.FromContext).
+// A token with a tenant_id claim binds the request to its store: another tenant from the host
+// or the header gets ErrTenantMismatch. It must follow Auth.
+func Tenant(cfg TenantConfig) (middleware.Middleware, error) {
+	for _, source := range cfg.Sources {
+		switch source {
+		case TenantFromClaim, TenantFromHeader:
+		case TenantFromHost:
+			if cfg.HostSuffix == "" {
+				return nil, errors.New("tenant host source requires a host suffix")
+			}
+		default:
+			return nil, errors.Errorf("unknown tenant source: %s", source)
+		}
+	}
+
+	hostSuffix := "." + strings.ToLower(strings.TrimPrefix(cfg.HostSuffix, "."))
+
+	return func(handler middleware.Handler) middleware.Handler {
+		return func(ctx context.Context, req any) (any, error) {
+			tr, ok := transport.FromServerContext(ctx)
+			if !ok {
+				return handler(ctx, req)
+			}
+
+			var claimed string
+			if claims, ok := ClaimsFromContext(ctx); ok {
+				claimed = claims.Tenant
+			}
+
+			var id string
+
+			for _, source := range cfg.Sources {
+				var found string
+
+				switch source {
+				case TenantFromClaim:
+					found = claimed
+				case TenantFromHost:
+					found = tenantFromHost(ctx, hostSuffix)
+				case TenantFromHeader:
+					found = tr.RequestHeader().Get(cfg.Header)
+				}
+
+				if found != "" {
+					id = strings.ToLower(found)
+
+					break
+				}
+			}
+
+			switch {
+			case id == "" && cfg.Required:
+				return nil, ErrTenantRequired
+			case id == "":
+				return handler(ctx, req)
+			case !tenant.IsValid(id):
+				return nil, ErrInvalidTenant
+			case claimed != "" && !strings.EqualFold(claimed, id):
+				return nil, ErrTenantMismatch
+			}
+
+			return handler(tenant.NewContext(ctx, id), req)
+		}
+	}, nil
+}
+
+// requestHeaders returns the request headers the tenant can come from, the claim is not one.
+func (cfg TenantConfig) requestHeaders() []string {
+	var headers []string
+
+	for _, source := range cfg.Sources {
+		switch source {
+		case TenantFromHost:
+			headers = append(headers, "Host")
+		case TenantFromHeader:
+			headers = append(headers, cfg.Header)
+		}
+	}
+
+	return headers
+}
+
+func tenantFromHost(ctx context.Context, hostSuffix string) string {
+	r, ok := khttp.RequestFromServerContext(ctx)
+	if !ok {
+		return ""
+	}
+
+	host := r.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+
+	subdomain, ok := strings.CutSuffix(strings.ToLower(host), hostSuffix)
+	if !ok || strings.Contains(subdomain, ".") {
+		return ""
+	}
+
+	return subdomain
+}
+
+// TenantClient forwards the tenant of the context in the X-Tenant-ID of outgoing HTTP and gRPC calls.
+func TenantClient() middleware.Middleware {
+	return func(handler middleware.Handler) middleware.Handler {
+		return func(ctx context.Context, req any) (any, error) {
+			if id := tenant.FromContext(ctx); id != "" {
+				if tr, ok := transport.FromClientContext(ctx); ok {
+					tr.RequestHeader().Set(tenant.Header, id)
+				}
+			}
+
+			return handler(ctx, req)
+		}
+	}
+}
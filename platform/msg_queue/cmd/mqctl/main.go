@@ -0,0 +1,153 @@
+// mqctl administrates kafka for the services. It connects to the cluster configured by
+// the same environment variables as msg_queue (see msg_queue.LoadKafkaOptions).
+//
+// Usage:
+//
+//	mqctl topics -spec topics.json [-dry-run]
+//	mqctl offsets -group name -topic name[:partition,...] -to earliest|latest|timestamp|offset [-dry-run]
+//
+// offsets resets the committed offsets of a stopped consumer group so the records are handled again,
+// -topic can be repeated and the timestamp is in RFC 3339.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+
+	"platform/msg_queue"
+
+	"github.com/goccy/go-json"
+)
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+
+	if err := run(os.Args[1], os.Args[2:]); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func run(command string, args []string) error {
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+
+	switch command {
+	case "topics":
+		return runTopics(ctx, args)
+	case "offsets":
+		return runOffsets(ctx, args)
+	default:
+		usage()
+
+		return nil
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: mqctl topics -spec topics.json [-dry-run]")
+	fmt.Fprintln(
+		os.Stderr,
+		"       mqctl offsets -group name -topic name[:partition,...] -to earliest|latest|timestamp|offset [-dry-run]",
+	)
+
+	os.Exit(2)
+}
+
+// runTopics reconciles the topics declared in a JSON file with a list of msg_queue.TopicSpec.
+func runTopics(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("topics", flag.ExitOnError)
+	specPath := fs.String("spec", "", "path to a JSON file with a list of topic specs")
+	dryRun := fs.Bool("dry-run", false, "only report the drift")
+
+	_ = fs.Parse(args)
+
+	if *specPath == "" {
+		usage()
+	}
+
+	data, err := os.ReadFile(*specPath)
+	if err != nil {
+		return err
+	}
+
+	var specs []msg_queue.TopicSpec
+
+	if err = json.Unmarshal(data, &specs); err != nil {
+		return fmt.Errorf("error occurred when unmarshalling topic specs: %w", err)
+	}
+
+	admin := msg_queue.MustCreateKafkaAdmin()
+	defer admin.Close()
+
+	drifts, err := msg_queue.ReconcileTopics(ctx, admin, *dryRun, specs...)
+	for _, drift := range drifts {
+		fmt.Println(drift)
+	}
+
+	if err == nil && len(drifts) == 0 {
+		fmt.Println("no drift")
+	}
+
+	return err
+}
+
+// runOffsets resets the committed offsets of a consumer group.
+func runOffsets(ctx context.Context, args []string) error {
+	topics := make(map[string][]int32)
+
+	fs := flag.NewFlagSet("offsets", flag.ExitOnError)
+	group := fs.String("group", "", "consumer group to reset")
+	to := fs.String("to", "", "earliest, latest, an RFC 3339 timestamp or an offset")
+	dryRun := fs.Bool("dry-run", false, "only print the changes")
+	fs.Func("topic", "topic to reset, optionally with a comma separated list of partitions after a colon", func(s string) error {
+		topic, list, _ := strings.Cut(s, ":")
+
+		var partitions []int32
+
+		for p := range strings.SplitSeq(list, ",") {
+			if p == "" {
+				continue
+			}
+
+			partition, err := strconv.ParseInt(p, 10, 32)
+			if err != nil {
+				return fmt.Errorf("invalid partition %q: %w", p, err)
+			}
+
+			partitions = append(partitions, int32(partition))
+		}
+
+		topics[topic] = append(topics[topic], partitions...)
+
+		return nil
+	})
+
+	_ = fs.Parse(args)
+
+	if *group == "" || *to == "" || len(topics) == 0 {
+		usage()
+	}
+
+	reset, err := msg_queue.ParseOffsetReset(*to)
+	if err != nil {
+		return err
+	}
+
+	admin := msg_queue.MustCreateKafkaAdmin()
+	defer admin.Close()
+
+	changes, err := msg_queue.ResetGroupOffsets(ctx, admin, *group, topics, reset, *dryRun)
+	for _, change := range changes {
+		fmt.Println(change)
+	}
+
+	return err
+}
@@ -0,0 +1,71 @@
+package msg_queue
+
+import (
+	"sync"
+
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+// flowControl counts the records in flight by partition, the polled records that are not committable yet
+// (see offsetTracker), and pauses fetching a partition once it reaches the high watermark,
+// until its handlers bring it down to the low watermark. A nacked record being redelivered
+// stays in flight, so the records behind it cannot pile up.
+// Paused partitions stay paused across rebalances, they are resumed when their records are done.
+type flowControl struct {
+	client  *kgo.Client
+	metrics *metricHooks
+	high    int
+	low     int
+
+	mu       sync.Mutex
+	inFlight map[topicPartition]int
+	paused   map[topicPartition]struct{}
+}
+
+func newFlowControl(client *kgo.Client, metrics *metricHooks, high, low int) *flowControl {
+	return &flowControl{
+		client:   client,
+		metrics:  metrics,
+		high:     high,
+		low:      low,
+		inFlight: make(map[topicPartition]int),
+		paused:   make(map[topicPartition]struct{}),
+	}
+}
+
+// acquire is called by the poll loop before a record is handed to a worker.
+func (f *flowControl) acquire(record *kgo.Record) {
+	tp := topicPartition{topic: record.Topic, partition: record.Partition}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.inFlight[tp]++
+
+	if _, ok := f.paused[tp]; ok || f.inFlight[tp] < f.high {
+		return
+	}
+
+	f.client.PauseFetchPartitions(map[string][]int32{tp.topic: {tp.partition}})
+	f.paused[tp] = struct{}{}
+	f.metrics.observePaused(len(f.paused))
+}
+
+// release is called when n records of the partition become committable or are dropped on revoke.
+func (f *flowControl) release(tp topicPartition, n int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.inFlight[tp] -= n
+	if f.inFlight[tp] <= 0 {
+		delete(f.inFlight, tp)
+	}
+
+	if _, ok := f.paused[tp]; !ok || f.inFlight[tp] > f.low {
+		return
+	}
+
+	f.client.ResumeFetchPartitions(map[string][]int32{tp.topic: {tp.partition}})
+	delete(f.paused, tp)
+	f.metrics.observePaused(len(f.paused))
+}
@@ -0,0 +1,69 @@
+package msg_queue
+
+import (
+	"context"
+
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+type (
+	HandleFunc = func(ctx context.Context, record *kgo.Record) error
+	Handler    struct {
+		fn HandleFunc
+
+		deadLetterTopic       string
+		isAckBeforeProcessing bool
+	}
+	HandlerTable = map[string]Handler
+)
+
+type HandlerOption func(*Handler)
+
+// AckBeforeProcessing makes the client commit a record before it is handled (at-most-once).
+func AckBeforeProcessing() HandlerOption {
+	return func(h *Handler) {
+		h.isAckBeforeProcessing = true
+	}
+}
+
+// WithDeadLetterTopic makes the client produce records whose handler returned an error
+// (including a recovered panic) to the given topic.
+func WithDeadLetterTopic(topic string) HandlerOption {
+	return func(h *Handler) {
+		h.deadLetterTopic = topic
+	}
+}
+
+// WithMiddleware wraps the handler with the given middlewares, the first one is the outermost.
+func WithMiddleware(m ...Middleware) HandlerOption {
+	return func(h *Handler) {
+		h.fn = Chain(m...)(h.fn)
+	}
+}
+
+// NewHandler wraps fn with Recovery as the innermost middleware, so a panic is handled as an error.
+func NewHandler(fn HandleFunc, opts ...HandlerOption) Handler {
+	h := Handler{
+		fn: Recovery()(fn),
+	}
+
+	for _, opt := range opts {
+		opt(&h)
+	}
+
+	return h
+}
+
+// UseMiddleware returns a copy of the table where every handler is wrapped with the given middlewares.
+// They run before the middlewares of a specific Handler.
+func UseMiddleware(table HandlerTable, m ...Middleware) HandlerTable {
+	chain := Chain(m...)
+	res := make(HandlerTable, len(table))
+
+	for topic, handler := range table {
+		handler.fn = chain(handler.fn)
+		res[topic] = handler
+	}
+
+	return res
+}
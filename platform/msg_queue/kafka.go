@@ -2,198 +2,280 @@ package msg_queue
 
 import (
 	"context"
-	"fmt"
+	"maps"
+	"slices"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"platform/logger"
 
-	"github.com/caarlos0/env/v11"
-	"github.com/goccy/go-json"
 	"github.com/panjf2000/ants/v2"
 	"github.com/pkg/errors"
 	"github.com/twmb/franz-go/pkg/kgo"
-	"github.com/twmb/franz-go/pkg/sasl/plain"
 )
 
-type (
-	HandleFunc = func(*kgo.Record)
-	Handler    struct {
-		fn HandleFunc
-
-		isAckBeforeProcessing bool
-	}
-	HandlerTable = map[string]Handler
+const (
+	kafkaRedeliveryBaseDelay = 100 * time.Millisecond
+	kafkaRedeliveryMaxDelay  = 30 * time.Second
+	kafkaAutoCommitInterval  = time.Second
 )
 
-type kafkaConfig struct {
-	Addrs    string `env:"KAFKA_ADDRS, required, notEmpty"`
-	Topics   string `env:"KAFKA_TOPICS, required, notEmpty"`
-	Group    string `env:"KAFKA_GROUP, required, notEmpty"`
-	User     string `env:"KAFKA_USER, required, notEmpty"`
-	Password string `env:"KAFKA_PASSWORD, required, notEmpty"`
-}
-
+// KGOClient is a MessageQueue of kafka. An acknowledged record is marked for the commit once every
+// earlier record of its partition is acknowledged, the marks are committed every second and when
+// the partitions are revoked. A nacked record is handled again with an exponential backoff.
 type KGOClient struct {
-	client *kgo.Client
-	table  HandlerTable
+	client  *kgo.Client
+	table   HandlerTable
+	metrics *metricHooks
+	flow    *flowControl
+	offsets *offsetTracker
+	// commitMu orders the marks of ack with the revocation of partitions.
+	commitMu      sync.Mutex
+	workers       *workers
+	maxGoroutines uint
+	// maxPollRecords bounds the records of a poll, and so the backlog of the workers.
+	maxPollRecords int
+	isSubscribed   atomic.Bool
 }
 
+var _ MessageQueue = (*KGOClient)(nil)
+
 func MustCreateKafkaClient(
 	table HandlerTable,
 	maxGoroutines uint,
 ) *KGOClient {
-	cfg, err := env.ParseAs[kafkaConfig]()
+	opts, err := LoadKafkaOptions()
 	if err != nil {
 		logger.Fatal(err.Error())
 
 		return nil
 	}
 
-	var addrsArr []string
-
-	if err = json.Unmarshal([]byte(cfg.Addrs), &addrsArr); err != nil {
-		logger.Fatal(fmt.Sprintf("error occurred when unmarshalling kafka Addrs: %v", err))
+	client, err := NewKafkaClient(opts, table, maxGoroutines)
+	if err != nil {
+		logger.Fatal(err.Error())
 
 		return nil
 	}
 
-	if len(addrsArr) == 0 {
-		logger.Fatal("error occurred when creating a kafka client: empty address")
+	return client
+}
 
-		return nil
+// NewKafkaClient creates a client and subscribes it to opts.Topics.
+func NewKafkaClient(opts KafkaOptions, table HandlerTable, maxGoroutines uint) (*KGOClient, error) {
+	subscribed, err := opts.subscribedTable(table)
+	if err != nil {
+		return nil, err
 	}
 
-	var topics []string
-
-	if err = json.Unmarshal([]byte(cfg.Topics), &addrsArr); err != nil {
-		logger.Fatal(fmt.Sprintf("error occurred when unmarshalling kafka Topics: %v", err))
-
-		return nil
+	maxPollRecords, high, low, err := opts.flowLimits()
+	if err != nil {
+		return nil, err
 	}
 
-	for _, topic := range topics {
-		if _, ok := table[topic]; !ok {
-			logger.Fatal(
-				fmt.Sprintf(
-					"error occurred when creating a kafka client: topic %s is not in the handle table",
-					topic,
-				),
-			)
-		}
+	client := &KGOClient{
+		metrics:        newMetricHooks(opts.Group),
+		offsets:        newOffsetTracker(),
+		maxGoroutines:  maxGoroutines,
+		maxPollRecords: maxPollRecords,
 	}
 
-	kgoClient, err := kgo.NewClient(
-		kgo.SeedBrokers(addrsArr...),
-		kgo.ConsumerGroup(cfg.Group),
-		kgo.ConsumeTopics(cfg.Topics),
-		kgo.FetchMinBytes(1<<10),
-		kgo.FetchMaxBytes(4<<20),
-		kgo.FetchMaxWait(time.Millisecond),
-		kgo.DisableAutoCommit(),
-		kgo.SASL(plain.Auth{
-			User: cfg.User,
-			Pass: cfg.Password,
-		}.AsMechanism()),
-		kgo.SessionTimeout(30*time.Second),
-	)
+	kgoOpts, err := opts.clientOpts(client.metrics, rebalanceCallbacks{
+		onRevoked: client.onRevoked,
+		onLost:    client.onLost,
+	})
 	if err != nil {
-		logger.Fatal(fmt.Sprintf("error occurred when creating a kafka client: %v", err))
+		return nil, err
 	}
 
-	client := &KGOClient{
-		client: kgoClient,
-		table:  table,
+	kgoClient, err := kgo.NewClient(append(
+		kgoOpts,
+		kgo.AutoCommitMarks(),
+		kgo.AutoCommitInterval(kafkaAutoCommitInterval),
+		kgo.AutoCommitCallback(client.metrics.onAutoCommit),
+		kgo.BlockRebalanceOnPoll(),
+	)...)
+	if err != nil {
+		return nil, errors.Wrap(err, "error occurred when creating a kafka client")
 	}
 
-	if len(topics) > 0 {
-		go client.startPolling(maxGoroutines)
+	client.client = kgoClient
+	client.flow = newFlowControl(kgoClient, client.metrics, high, low)
+
+	if len(subscribed) > 0 {
+		if err = client.Subscribe(subscribed); err != nil {
+			kgoClient.Close()
+
+			return nil, err
+		}
 	}
 
-	return client
+	return client, nil
 }
 
-func (client *KGOClient) ack(record *kgo.Record) {
-	if err := client.client.CommitRecords(client.client.Context(), record); err == nil {
-		logger.Errorf("error occurred when committing record: %v", err)
+// Subscribe starts consuming the topics of the table in a new goroutine.
+func (client *KGOClient) Subscribe(table HandlerTable) error {
+	if !client.isSubscribed.CompareAndSwap(false, true) {
+		return ErrAlreadySubscribed
 	}
-}
 
-// startPolling does not run a new goroutine and should be called in a new one.
-func (client *KGOClient) startPolling(maxGoroutines uint) {
+	maxGoroutines := client.maxGoroutines
 	if maxGoroutines == 0 {
 		maxGoroutines = 10000
 	}
 
-	type workerArgs struct {
-		client *KGOClient
-		record *kgo.Record
-	}
-
 	pool, err := ants.NewPoolWithFuncGeneric(
 		int(maxGoroutines),
 		func(args workerArgs) {
-			record := args.record
-			table := args.client.table
-			handler := table[record.Topic]
-
-			if handler.isAckBeforeProcessing {
-				args.client.ack(record)
-			}
-
-			handler.fn(args.record)
-
-			if !handler.isAckBeforeProcessing {
-				args.client.ack(record)
-			}
+			args.client.work(args)
 		},
 		ants.WithLogger(logger.MainLogger()),
 		ants.WithPanicHandler(func(err interface{}) {
-			logger.Fatalf("panic occurred in kafka worker: %v", err)
+			logger.Errorf("panic occurred in kafka worker: %v", err)
 		}),
 		ants.WithExpiryDuration(time.Minute),
 		ants.WithNonblocking(false),
 	)
 	if err != nil {
-		logger.Fatal(fmt.Sprintf("error occurred when creating a kafka worker pool: %v", err))
+		client.isSubscribed.Store(false)
+
+		return errors.Wrap(err, "error occurred when creating a kafka worker pool")
+	}
+
+	client.table = UseMiddleware(table, client.metrics.handlerMetrics)
+	topics := slices.Collect(maps.Keys(table))
+	client.workers = newWorkers(client.client, pool, topics)
+
+	client.client.AddConsumeTopics(topics...)
+
+	go client.startPolling(pool)
+
+	return nil
+}
+
+// ack marks the acknowledged prefix of the partition for the next commit.
+func (client *KGOClient) ack(record *kgo.Record) {
+	client.commitMu.Lock()
+	defer client.commitMu.Unlock()
+
+	commit, n := client.offsets.done(record)
+	if n == 0 {
+		return
 	}
 
+	client.client.MarkCommitRecords(commit)
+	client.flow.release(topicPartition{topic: record.Topic, partition: record.Partition}, n)
+}
+
+// nack hands the record to a worker again after a backoff, it holds back the commit of its partition
+// until it is acknowledged. The redelivery is dropped if the partition is revoked in the meantime.
+func (client *KGOClient) nack(record *kgo.Record) {
+	attempts, ok := client.offsets.retry(record)
+	if !ok {
+		return
+	}
+
+	delay := min(kafkaRedeliveryBaseDelay<<min(attempts-1, 16), kafkaRedeliveryMaxDelay)
+
+	time.AfterFunc(delay, func() {
+		if client.client.Context().Err() != nil || !client.offsets.isTracked(record) {
+			return
+		}
+
+		client.workers.dispatch(workerArgs{client: client, record: record})
+	})
+}
+
+// onRevoked commits the marked offsets before the partitions move to another member.
+func (client *KGOClient) onRevoked(ctx context.Context, cl *kgo.Client, revoked map[string][]int32) {
+	client.commitMu.Lock()
+	defer client.commitMu.Unlock()
+
+	if err := cl.CommitMarkedOffsets(ctx); err != nil {
+		for topic := range revoked {
+			client.metrics.observeCommitFailure(topic)
+		}
+
+		logger.Errorf("error occurred when committing offsets of revoked partitions: %v", err)
+	}
+
+	client.forgetLocked(revoked)
+}
+
+// onLost forgets the records of the partitions, the new owner consumes them from the committed offsets.
+func (client *KGOClient) onLost(_ context.Context, _ *kgo.Client, lost map[string][]int32) {
+	client.commitMu.Lock()
+	defer client.commitMu.Unlock()
+
+	client.forgetLocked(lost)
+}
+
+// forgetLocked drops the tracked records of the partitions. client.commitMu must be held.
+func (client *KGOClient) forgetLocked(partitions map[string][]int32) {
+	for tp, n := range client.offsets.forget(partitions) {
+		client.flow.release(tp, n)
+	}
+}
+
+type workerArgs struct {
+	client *KGOClient
+	record *kgo.Record
+}
+
+// work handles the record and then the backlog of the workers. The records of the partitions revoked
+// while they were waiting are skipped.
+func (client *KGOClient) work(args workerArgs) {
+	for ok := true; ok; args, ok = client.workers.next() {
+		if client.offsets.isTracked(args.record) {
+			client.process(args.record)
+		}
+	}
+}
+
+func (client *KGOClient) process(record *kgo.Record) {
+	client.table[record.Topic].process(client.client.Context(), client, record, client.ack, client.nack)
+}
+
+// startPolling does not run a new goroutine and should be called in a new one.
+// The loop never waits for workers: a partition whose records are not committable pile up is paused
+// (see flowControl), and all the topics are paused while every worker is busy (see workers).
+func (client *KGOClient) startPolling(pool *ants.PoolWithFuncGeneric[workerArgs]) {
 	ctx := client.client.Context()
 
+	go client.metrics.watchWorkers(ctx, client.workers)
+	go client.metrics.watchLag(ctx, client.client)
+
 	for ctx.Err() == nil {
-		fetches := client.client.PollFetches(ctx)
-		if errs := fetches.Errors(); len(errs) > 0 {
-			for _, e := range errs {
-				logger.Errorf("error occurred when polling fetches: %v", e)
-			}
+		fetches := client.client.PollRecords(ctx, client.maxPollRecords)
 
-			continue
+		// An error concerns its partition only, the records of the others are handled.
+		for _, e := range fetches.Errors() {
+			logger.Errorf("error occurred when polling topic %s, partition %d: %v", e.Topic, e.Partition, e.Err)
 		}
 
 		fetches.EachRecord(func(record *kgo.Record) {
-			err = pool.Invoke(workerArgs{
-				client: client,
-				record: record,
-			})
-			if err != nil {
-				logger.Errorf("error occurred when invoking a kafka worker: %v", err)
-			}
+			client.flow.acquire(record)
+			client.offsets.track(record)
+			client.workers.dispatch(workerArgs{client: client, record: record})
 		})
+
+		// The records are tracked, a revocation can forget them now.
+		client.client.AllowRebalance()
 	}
 
 	pool.Release()
 }
 
 func (client *KGOClient) Produce(ctx context.Context, topic string, value []byte) error {
-	record := &kgo.Record{
+	return client.ProduceRecord(ctx, &kgo.Record{
 		Topic: topic,
 		Value: value,
-	}
-
-	if err := client.client.ProduceSync(ctx, record).FirstErr(); err != nil {
-		return errors.Wrap(err, "error occurred when producing a record")
-	}
+	})
+}
 
-	return nil
+func (client *KGOClient) ProduceRecord(ctx context.Context, record *kgo.Record) error {
+	return produceSync(ctx, client.client, record)
 }
 
 func (client *KGOClient) Close() {
@@ -0,0 +1,181 @@
+package msg_queue
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/twmb/franz-go/pkg/kadm"
+	"github.com/twmb/franz-go/pkg/kfake"
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+const testGroup = "test-group"
+
+func newTestCluster(t *testing.T, partitions int32, topics ...string) []string {
+	t.Helper()
+
+	cluster, err := kfake.NewCluster(kfake.NumBrokers(1), kfake.SeedTopics(partitions, topics...))
+	require.NoError(t, err)
+
+	t.Cleanup(cluster.Close)
+
+	return cluster.ListenAddrs()
+}
+
+// committedOffset returns the committed offset of the partition for testGroup, -1 if there is none.
+func committedOffset(t *testing.T, addrs []string, topic string, partition int32) int64 {
+	t.Helper()
+
+	cl, err := kgo.NewClient(kgo.SeedBrokers(addrs...))
+	require.NoError(t, err)
+
+	defer cl.Close()
+
+	offsets, err := kadm.NewClient(cl).FetchOffsets(context.Background(), testGroup)
+	require.NoError(t, err)
+
+	offset, ok := offsets.Lookup(topic, partition)
+	if !ok {
+		return -1
+	}
+
+	return offset.At
+}
+
+func TestKGOClientNackRedeliversAndHoldsBackCommit(t *testing.T) {
+	addrs := newTestCluster(t, 1, "orders")
+
+	var (
+		isFailing     atomic.Bool
+		firstAttempts atomic.Int32
+		handled       atomic.Int32
+	)
+
+	isFailing.Store(true)
+
+	table := HandlerTable{
+		"orders": NewHandler(func(_ context.Context, record *kgo.Record) error {
+			if record.Offset == 0 {
+				firstAttempts.Add(1)
+
+				if isFailing.Load() {
+					return errors.New("failed")
+				}
+			}
+
+			handled.Add(1)
+
+			return nil
+		}),
+	}
+
+	client, err := NewKafkaClient(KafkaOptions{Addrs: addrs, Group: testGroup, Topics: []string{"orders"}}, table, 10)
+	require.NoError(t, err)
+
+	defer client.Close()
+
+	for range 3 {
+		require.NoError(t, client.Produce(context.Background(), "orders", []byte("order")))
+	}
+
+	require.Eventually(t, func() bool {
+		return handled.Load() == 2 && firstAttempts.Load() >= 3
+	}, 10*time.Second, 10*time.Millisecond, "the nacked record is redelivered")
+
+	// The records after the nacked one are handled but not committed past it.
+	time.Sleep(2 * kafkaAutoCommitInterval)
+	assert.Equal(t, int64(-1), committedOffset(t, addrs, "orders", 0))
+
+	isFailing.Store(false)
+
+	require.Eventually(t, func() bool {
+		return committedOffset(t, addrs, "orders", 0) == 3
+	}, 10*time.Second, 50*time.Millisecond, "the partition is committed once the nacked record succeeds")
+
+	assert.Equal(t, int32(3), handled.Load())
+}
+
+func TestKGOClientDeadLetterAcknowledges(t *testing.T) {
+	addrs := newTestCluster(t, 1, "orders", "orders-dlq")
+
+	var handled atomic.Int32
+
+	table := HandlerTable{
+		"orders": NewHandler(func(_ context.Context, record *kgo.Record) error {
+			if record.Offset == 0 {
+				return errors.New("failed")
+			}
+
+			handled.Add(1)
+
+			return nil
+		}, WithDeadLetterTopic("orders-dlq")),
+	}
+
+	client, err := NewKafkaClient(KafkaOptions{Addrs: addrs, Group: testGroup, Topics: []string{"orders"}}, table, 10)
+	require.NoError(t, err)
+
+	defer client.Close()
+
+	for range 2 {
+		require.NoError(t, client.Produce(context.Background(), "orders", []byte("order")))
+	}
+
+	require.Eventually(t, func() bool {
+		return committedOffset(t, addrs, "orders", 0) == 2
+	}, 10*time.Second, 50*time.Millisecond)
+
+	assert.Equal(t, int32(1), handled.Load())
+}
+
+func TestKGOClientPausesTopicsWhenWorkersAreBusy(t *testing.T) {
+	addrs := newTestCluster(t, 1, "orders")
+
+	var (
+		release = make(chan struct{})
+		handled atomic.Int32
+	)
+
+	table := HandlerTable{
+		"orders": NewHandler(func(_ context.Context, record *kgo.Record) error {
+			if record.Offset == 0 {
+				<-release
+			}
+
+			handled.Add(1)
+
+			return nil
+		}),
+	}
+
+	client, err := NewKafkaClient(KafkaOptions{Addrs: addrs, Group: testGroup, Topics: []string{"orders"}}, table, 1)
+	require.NoError(t, err)
+
+	defer client.Close()
+
+	for range 5 {
+		require.NoError(t, client.Produce(context.Background(), "orders", []byte("order")))
+	}
+
+	// The only worker is busy, the poll loop keeps the next records in the backlog instead of blocking.
+	require.Eventually(t, func() bool {
+		return client.workers.waiting() > 0
+	}, 10*time.Second, 10*time.Millisecond)
+
+	assert.Equal(t, []string{"orders"}, client.client.PauseFetchTopics())
+	assert.Zero(t, handled.Load())
+
+	close(release)
+
+	require.Eventually(t, func() bool {
+		return committedOffset(t, addrs, "orders", 0) == 5
+	}, 10*time.Second, 50*time.Millisecond)
+
+	assert.Equal(t, int32(5), handled.Load())
+	assert.Empty(t, client.client.PauseFetchTopics())
+}
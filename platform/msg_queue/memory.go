@@ -0,0 +1,425 @@
+package msg_queue
+
+import (
+	"context"
+	"hash/fnv"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"platform/tracing"
+
+	"github.com/pkg/errors"
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+const (
+	defaultMemoryPartitions = 4
+	memoryRedeliveryDelay   = 100 * time.Millisecond
+	memoryFetchMaxRecords   = 500
+)
+
+// ErrClosed is returned when a closed client is used.
+var ErrClosed = errors.New("message queue client is closed")
+
+type topicPartition struct {
+	topic     string
+	partition int32
+}
+
+type memoryRedelivery struct {
+	record    *kgo.Record
+	notBefore time.Time
+}
+
+type memoryGroup struct {
+	members    []*MemoryClient
+	next       map[topicPartition]int64
+	committed  map[topicPartition]int64
+	redelivery map[topicPartition][]memoryRedelivery
+	offsets    *offsetTracker
+}
+
+// MemoryBroker is an in-process broker with partitioned topics, consumer groups and redelivery.
+// It is meant for tests and local runs where Kafka is not available. Like Kafka, a partition
+// of a topic is consumed by one member of a group at a time, a new group starts from
+// the earliest offset and uncommitted records of a leaving member are redelivered to the others.
+// A nacked record is redelivered to the member after a delay and holds back the commit of its partition.
+type MemoryBroker struct {
+	mu         sync.Mutex
+	partitions int32
+	topics     map[string][][]*kgo.Record
+	groups     map[string]*memoryGroup
+	roundRobin map[string]int32
+	changed    chan struct{}
+}
+
+func NewMemoryBroker(partitions int32) *MemoryBroker {
+	if partitions <= 0 {
+		partitions = defaultMemoryPartitions
+	}
+
+	return &MemoryBroker{
+		partitions: partitions,
+		topics:     make(map[string][][]*kgo.Record),
+		groups:     make(map[string]*memoryGroup),
+		roundRobin: make(map[string]int32),
+		changed:    make(chan struct{}),
+	}
+}
+
+// DefaultMemoryBroker returns the process-wide MemoryBroker.
+var DefaultMemoryBroker = sync.OnceValue(func() *MemoryBroker {
+	return NewMemoryBroker(defaultMemoryPartitions)
+})
+
+// Client creates a client of the broker that consumes within the given group.
+func (b *MemoryBroker) Client(group string, maxGoroutines uint) *MemoryClient {
+	if maxGoroutines == 0 {
+		maxGoroutines = 10000
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	return &MemoryClient{
+		broker:        b,
+		group:         group,
+		maxGoroutines: maxGoroutines,
+		ctx:           ctx,
+		cancel:        cancel,
+		done:          make(chan struct{}),
+	}
+}
+
+// notifyLocked wakes up every polling client. b.mu must be held.
+func (b *MemoryBroker) notifyLocked() {
+	close(b.changed)
+	b.changed = make(chan struct{})
+}
+
+func (b *MemoryBroker) produce(record *kgo.Record) error {
+	if record.Topic == "" {
+		return errors.New("error occurred when producing a record: empty topic")
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	partitions, ok := b.topics[record.Topic]
+	if !ok {
+		partitions = make([][]*kgo.Record, b.partitions)
+		b.topics[record.Topic] = partitions
+	}
+
+	var partition int32
+
+	if record.Key != nil {
+		hash := fnv.New32a()
+		_, _ = hash.Write(record.Key)
+		partition = int32(hash.Sum32() % uint32(b.partitions))
+	} else {
+		partition = b.roundRobin[record.Topic]
+		b.roundRobin[record.Topic] = (partition + 1) % b.partitions
+	}
+
+	stored := cloneRecord(record)
+	stored.Partition = partition
+	stored.Offset = int64(len(partitions[partition]))
+
+	if stored.Timestamp.IsZero() {
+		stored.Timestamp = time.Now()
+	}
+
+	partitions[partition] = append(partitions[partition], stored)
+
+	record.Partition = stored.Partition
+	record.Offset = stored.Offset
+	record.Timestamp = stored.Timestamp
+
+	b.notifyLocked()
+
+	return nil
+}
+
+func (b *MemoryBroker) join(client *MemoryClient) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	group, ok := b.groups[client.group]
+	if !ok {
+		group = &memoryGroup{
+			next:       make(map[topicPartition]int64),
+			committed:  make(map[topicPartition]int64),
+			redelivery: make(map[topicPartition][]memoryRedelivery),
+			offsets:    newOffsetTracker(),
+		}
+		b.groups[client.group] = group
+	}
+
+	group.members = append(group.members, client)
+
+	b.rebalanceLocked(group)
+}
+
+func (b *MemoryBroker) leave(client *MemoryClient) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	group, ok := b.groups[client.group]
+	if !ok {
+		return
+	}
+
+	group.members = slices.DeleteFunc(group.members, func(member *MemoryClient) bool {
+		return member == client
+	})
+
+	b.rebalanceLocked(group)
+}
+
+// rebalanceLocked rewinds every partition of the group to its committed offset,
+// so records that were handed out but not acknowledged are delivered again. b.mu must be held.
+func (b *MemoryBroker) rebalanceLocked(group *memoryGroup) {
+	group.offsets.forgetAll()
+	clear(group.redelivery)
+
+	for tp, committed := range group.committed {
+		group.next[tp] = committed
+	}
+
+	for tp := range group.next {
+		if _, ok := group.committed[tp]; !ok {
+			delete(group.next, tp)
+		}
+	}
+
+	b.notifyLocked()
+}
+
+// ownerLocked returns the member of the group that consumes the partition. b.mu must be held.
+func (group *memoryGroup) ownerLocked(tp topicPartition) *MemoryClient {
+	var subscribed []*MemoryClient
+
+	for _, member := range group.members {
+		if _, ok := member.table[tp.topic]; ok {
+			subscribed = append(subscribed, member)
+		}
+	}
+
+	if len(subscribed) == 0 {
+		return nil
+	}
+
+	return subscribed[int(tp.partition)%len(subscribed)]
+}
+
+// fetch returns the records to be handled by the client, the time when a pending redelivery
+// becomes due and a channel that is closed on the next change of the broker.
+func (b *MemoryBroker) fetch(client *MemoryClient) ([]*kgo.Record, time.Duration, <-chan struct{}) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	group := b.groups[client.group]
+	now := time.Now()
+	wait := time.Duration(-1)
+
+	var records []*kgo.Record
+
+	for topic := range client.table {
+		for partition, log := range b.topics[topic] {
+			tp := topicPartition{topic: topic, partition: int32(partition)}
+
+			if group.ownerLocked(tp) != client {
+				continue
+			}
+
+			pending := group.redelivery[tp]
+			for len(pending) > 0 && len(records) < memoryFetchMaxRecords {
+				if delay := pending[0].notBefore.Sub(now); delay > 0 {
+					if wait < 0 || delay < wait {
+						wait = delay
+					}
+
+					break
+				}
+
+				records = append(records, pending[0].record)
+				pending = pending[1:]
+			}
+
+			group.redelivery[tp] = pending
+
+			for next := group.next[tp]; next < int64(len(log)) && len(records) < memoryFetchMaxRecords; next++ {
+				record := cloneRecord(log[next])
+				group.offsets.track(record)
+				records = append(records, record)
+				group.next[tp] = next + 1
+			}
+		}
+	}
+
+	return records, wait, b.changed
+}
+
+func (b *MemoryBroker) ack(group string, record *kgo.Record) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	g := b.groups[group]
+
+	if commit, n := g.offsets.done(record); n > 0 {
+		g.committed[topicPartition{topic: commit.Topic, partition: commit.Partition}] = commit.Offset + 1
+	}
+}
+
+func (b *MemoryBroker) nack(group string, record *kgo.Record) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	tp := topicPartition{topic: record.Topic, partition: record.Partition}
+	g := b.groups[group]
+
+	// The record was handed out before a rebalance, it is delivered again from the committed offset.
+	if _, ok := g.offsets.retry(record); !ok {
+		return
+	}
+
+	g.redelivery[tp] = append(g.redelivery[tp], memoryRedelivery{
+		record:    record,
+		notBefore: time.Now().Add(memoryRedeliveryDelay),
+	})
+
+	b.notifyLocked()
+}
+
+// MemoryClient is a MessageQueue of a MemoryBroker.
+type MemoryClient struct {
+	broker        *MemoryBroker
+	group         string
+	table         HandlerTable
+	maxGoroutines uint
+	isSubscribed  atomic.Bool
+	ctx           context.Context
+	cancel        context.CancelFunc
+	done          chan struct{}
+}
+
+var _ MessageQueue = (*MemoryClient)(nil)
+
+func (client *MemoryClient) Produce(ctx context.Context, topic string, value []byte) error {
+	return client.ProduceRecord(ctx, &kgo.Record{
+		Topic: topic,
+		Value: value,
+	})
+}
+
+func (client *MemoryClient) ProduceRecord(ctx context.Context, record *kgo.Record) error {
+	if err := ctx.Err(); err != nil {
+		return errors.Wrap(err, "error occurred when producing a record")
+	}
+
+	if client.ctx.Err() != nil {
+		return ErrClosed
+	}
+
+	withRequestID(ctx, record)
+
+	_, span := startProducerSpan(ctx, record)
+
+	err := client.broker.produce(record)
+
+	tracing.EndSpan(span, err)
+
+	return err
+}
+
+// Subscribe joins the group of the client and starts consuming the topics of the table in a new goroutine.
+func (client *MemoryClient) Subscribe(table HandlerTable) error {
+	if client.ctx.Err() != nil {
+		return ErrClosed
+	}
+
+	if !client.isSubscribed.CompareAndSwap(false, true) {
+		return ErrAlreadySubscribed
+	}
+
+	client.table = table
+
+	client.broker.join(client)
+
+	go client.poll()
+
+	return nil
+}
+
+func (client *MemoryClient) poll() {
+	defer close(client.done)
+
+	var wg sync.WaitGroup
+
+	defer wg.Wait()
+
+	sem := make(chan struct{}, client.maxGoroutines)
+
+	for {
+		records, wait, changed := client.broker.fetch(client)
+
+		for _, record := range records {
+			select {
+			case sem <- struct{}{}:
+			case <-client.ctx.Done():
+				return
+			}
+
+			wg.Go(func() {
+				defer func() { <-sem }()
+
+				client.table[record.Topic].process(client.ctx, client, record, client.ack, client.nack)
+			})
+		}
+
+		if len(records) > 0 {
+			continue
+		}
+
+		var timer <-chan time.Time
+		if wait >= 0 {
+			timer = time.After(wait)
+		}
+
+		select {
+		case <-client.ctx.Done():
+			return
+		case <-changed:
+		case <-timer:
+		}
+	}
+}
+
+func (client *MemoryClient) ack(record *kgo.Record) {
+	client.broker.ack(client.group, record)
+}
+
+func (client *MemoryClient) nack(record *kgo.Record) {
+	client.broker.nack(client.group, record)
+}
+
+// Close leaves the group and waits for the running handlers.
+func (client *MemoryClient) Close() {
+	client.cancel()
+
+	if client.isSubscribed.Load() {
+		client.broker.leave(client)
+
+		<-client.done
+	}
+}
+
+func cloneRecord(record *kgo.Record) *kgo.Record {
+	clone := *record
+	clone.Headers = slices.Clone(record.Headers)
+	clone.Context = nil
+
+	return &clone
+}
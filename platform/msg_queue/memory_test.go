@@ -0,0 +1,170 @@
+package msg_queue
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+func (b *MemoryBroker) committedOffset(group string, topic string, partition int32) int64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	committed, ok := b.groups[group].committed[topicPartition{topic: topic, partition: partition}]
+	if !ok {
+		return -1
+	}
+
+	return committed
+}
+
+func TestMemoryClientNackRedeliversAndHoldsBackCommit(t *testing.T) {
+	broker := NewMemoryBroker(1)
+
+	var (
+		isFailing     atomic.Bool
+		firstAttempts atomic.Int32
+		handled       atomic.Int32
+	)
+
+	isFailing.Store(true)
+
+	client := broker.Client(testGroup, 10)
+	defer client.Close()
+
+	require.NoError(t, client.Subscribe(HandlerTable{
+		"orders": NewHandler(func(_ context.Context, record *kgo.Record) error {
+			if record.Offset == 0 {
+				firstAttempts.Add(1)
+
+				if isFailing.Load() {
+					return errors.New("failed")
+				}
+			}
+
+			handled.Add(1)
+
+			return nil
+		}),
+	}))
+
+	for range 3 {
+		require.NoError(t, client.Produce(context.Background(), "orders", []byte("order")))
+	}
+
+	require.Eventually(t, func() bool {
+		return handled.Load() == 2 && firstAttempts.Load() >= 3
+	}, 5*time.Second, 10*time.Millisecond, "the nacked record is redelivered")
+
+	assert.Equal(t, int64(-1), broker.committedOffset(testGroup, "orders", 0))
+
+	isFailing.Store(false)
+
+	require.Eventually(t, func() bool {
+		return broker.committedOffset(testGroup, "orders", 0) == 3
+	}, 5*time.Second, 10*time.Millisecond, "the partition is committed once the nacked record succeeds")
+
+	assert.Equal(t, int32(3), handled.Load())
+}
+
+func TestMemoryClientResumesFromNackedRecord(t *testing.T) {
+	broker := NewMemoryBroker(1)
+
+	first := broker.Client(testGroup, 10)
+
+	var firstHandled atomic.Int32
+
+	require.NoError(t, first.Subscribe(HandlerTable{
+		"orders": NewHandler(func(_ context.Context, record *kgo.Record) error {
+			if record.Offset == 1 {
+				return errors.New("failed")
+			}
+
+			firstHandled.Add(1)
+
+			return nil
+		}),
+	}))
+
+	for range 3 {
+		require.NoError(t, first.Produce(context.Background(), "orders", []byte("order")))
+	}
+
+	require.Eventually(t, func() bool {
+		return firstHandled.Load() == 2
+	}, 5*time.Second, 10*time.Millisecond)
+
+	first.Close()
+
+	assert.Equal(t, int64(1), broker.committedOffset(testGroup, "orders", 0))
+
+	offsets := make(chan int64, 3)
+
+	second := broker.Client(testGroup, 10)
+	defer second.Close()
+
+	require.NoError(t, second.Subscribe(HandlerTable{
+		"orders": NewHandler(func(_ context.Context, record *kgo.Record) error {
+			offsets <- record.Offset
+
+			return nil
+		}),
+	}))
+
+	received := make(map[int64]bool)
+	for range 2 {
+		select {
+		case offset := <-offsets:
+			received[offset] = true
+		case <-time.After(5 * time.Second):
+			t.Fatal("the records after the committed offset are not redelivered")
+		}
+	}
+
+	assert.Equal(t, map[int64]bool{1: true, 2: true}, received)
+}
+
+func TestMemoryClientSendsPanickingRecordToDeadLetterTopic(t *testing.T) {
+	broker := NewMemoryBroker(1)
+
+	client := broker.Client(testGroup, 10)
+	defer client.Close()
+
+	deadLetters := make(chan *kgo.Record, 1)
+
+	dlq := broker.Client("dlq-group", 10)
+	defer dlq.Close()
+
+	require.NoError(t, dlq.Subscribe(HandlerTable{
+		"orders-dlq": NewHandler(func(_ context.Context, record *kgo.Record) error {
+			deadLetters <- record
+
+			return nil
+		}),
+	}))
+
+	require.NoError(t, client.Subscribe(HandlerTable{
+		"orders": NewHandler(func(context.Context, *kgo.Record) error {
+			panic("boom")
+		}, WithDeadLetterTopic("orders-dlq")),
+	}))
+
+	require.NoError(t, client.Produce(context.Background(), "orders", []byte("order")))
+
+	select {
+	case record := <-deadLetters:
+		assert.Equal(t, []byte("order"), record.Value)
+	case <-time.After(5 * time.Second):
+		t.Fatal("the panicking record is not sent to the dead letter topic")
+	}
+
+	require.Eventually(t, func() bool {
+		return broker.committedOffset(testGroup, "orders", 0) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+}
@@ -0,0 +1,410 @@
+package msg_queue
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"time"
+
+	"platform/logger"
+	"platform/metric"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/twmb/franz-go/pkg/kadm"
+	"github.com/twmb/franz-go/pkg/kerr"
+	"github.com/twmb/franz-go/pkg/kgo"
+	"github.com/twmb/franz-go/pkg/kmsg"
+)
+
+type kafkaMetrics struct {
+	consumerLag      *prometheus.GaugeVec
+	recordsConsumed  *prometheus.CounterVec
+	recordsProduced  *prometheus.CounterVec
+	produceErrors    *prometheus.CounterVec
+	handlerDuration  *prometheus.HistogramVec
+	handlerErrors    *prometheus.CounterVec
+	chainDuration    *prometheus.HistogramVec
+	chainErrors      *prometheus.CounterVec
+	commitFailures   *prometheus.CounterVec
+	rebalances       *prometheus.CounterVec
+	groupErrors      *prometheus.CounterVec
+	workerPoolActive *prometheus.GaugeVec
+	workerPoolQueued *prometheus.GaugeVec
+	pausedPartitions *prometheus.GaugeVec
+	transactions     *prometheus.CounterVec
+	deliveryLateness *prometheus.HistogramVec
+}
+
+// getKafkaMetrics returns the process-wide kafka metrics.
+// They are registered once, so several clients can live in one process.
+var getKafkaMetrics = sync.OnceValue(func() *kafkaMetrics {
+	m := &kafkaMetrics{
+		consumerLag: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "kafka_consumer_lag",
+				Help: "Number of records between the committed offset and the end offset of the assigned partitions",
+			},
+			[]string{"group", "topic", "partition"},
+		),
+		recordsConsumed: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "kafka_records_consumed_total",
+				Help: "Number of records polled from kafka by topic",
+			},
+			[]string{"group", "topic"},
+		),
+		recordsProduced: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "kafka_records_produced_total",
+				Help: "Number of records successfully produced to kafka by topic",
+			},
+			[]string{"topic"},
+		),
+		produceErrors: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "kafka_produce_errors_total",
+				Help: "Number of records that failed to be produced by topic",
+			},
+			[]string{"topic"},
+		),
+		handlerDuration: prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Name:    "kafka_handler_duration_seconds",
+				Help:    "Duration of record handling by topic",
+				Buckets: metric.SecondsBuckets(),
+			},
+			[]string{"group", "topic"},
+		),
+		handlerErrors: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "kafka_handler_errors_total",
+				Help: "Number of records whose handler returned an error by topic",
+			},
+			[]string{"group", "topic"},
+		),
+		chainDuration: prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Name:    "kafka_middleware_duration_seconds",
+				Help:    "Duration of the handler part wrapped by the Metrics middleware by topic",
+				Buckets: metric.SecondsBuckets(),
+			},
+			[]string{"topic"},
+		),
+		chainErrors: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "kafka_middleware_errors_total",
+				Help: "Number of errors returned by the handler part wrapped by the Metrics middleware by topic",
+			},
+			[]string{"topic"},
+		),
+		commitFailures: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "kafka_commit_failures_total",
+				Help: "Number of failed offset commits by topic",
+			},
+			[]string{"group", "topic"},
+		),
+		rebalances: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "kafka_rebalances_total",
+				Help: "Number of consumer group rebalance events by type (assigned, revoked, lost)",
+			},
+			[]string{"group", "event"},
+		),
+		groupErrors: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "kafka_group_errors_total",
+				Help: "Number of errors occurred while managing the consumer group",
+			},
+			[]string{"group"},
+		),
+		workerPoolActive: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "kafka_worker_pool_running",
+				Help: "Number of running kafka workers",
+			},
+			[]string{"group"},
+		),
+		workerPoolQueued: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "kafka_worker_pool_waiting",
+				Help: "Number of records waiting for a free kafka worker",
+			},
+			[]string{"group"},
+		),
+		pausedPartitions: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "kafka_paused_partitions",
+				Help: "Number of partitions paused because of too many records in flight",
+			},
+			[]string{"group"},
+		),
+		transactions: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "kafka_transactions_total",
+				Help: "Number of ended kafka transactions by result (committed, aborted)",
+			},
+			[]string{"group", "result"},
+		),
+		deliveryLateness: prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Name:    "kafka_scheduled_delivery_lateness_seconds",
+				Help:    "Delay between the requested and the actual delivery time of scheduled records by topic",
+				Buckets: []float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 300},
+			},
+			[]string{"topic"},
+		),
+	}
+
+	prometheus.MustRegister(
+		m.consumerLag,
+		m.recordsConsumed,
+		m.recordsProduced,
+		m.produceErrors,
+		m.handlerDuration,
+		m.handlerErrors,
+		m.chainDuration,
+		m.chainErrors,
+		m.commitFailures,
+		m.rebalances,
+		m.groupErrors,
+		m.workerPoolActive,
+		m.workerPoolQueued,
+		m.pausedPartitions,
+		m.transactions,
+		m.deliveryLateness,
+	)
+
+	return m
+})
+
+// metricHooks implements franz-go hooks to feed kafkaMetrics.
+type metricHooks struct {
+	group   string
+	metrics *kafkaMetrics
+}
+
+var (
+	_ kgo.HookFetchRecordUnbuffered   = (*metricHooks)(nil)
+	_ kgo.HookProduceRecordUnbuffered = (*metricHooks)(nil)
+	_ kgo.HookGroupManageError        = (*metricHooks)(nil)
+)
+
+func newMetricHooks(group string) *metricHooks {
+	return &metricHooks{
+		group:   group,
+		metrics: getKafkaMetrics(),
+	}
+}
+
+func (h *metricHooks) OnFetchRecordUnbuffered(record *kgo.Record, polled bool) {
+	if !polled {
+		return
+	}
+
+	h.metrics.recordsConsumed.WithLabelValues(h.group, record.Topic).Inc()
+}
+
+func (h *metricHooks) OnProduceRecordUnbuffered(record *kgo.Record, err error) {
+	if err != nil {
+		h.metrics.produceErrors.WithLabelValues(record.Topic).Inc()
+
+		return
+	}
+
+	h.metrics.recordsProduced.WithLabelValues(record.Topic).Inc()
+}
+
+func (h *metricHooks) OnGroupManageError(error) {
+	h.metrics.groupErrors.WithLabelValues(h.group).Inc()
+}
+
+// rebalanceCallbacks are called by the group options of rebalanceOpts, a nil one is skipped.
+type rebalanceCallbacks struct {
+	onRevoked func(context.Context, *kgo.Client, map[string][]int32)
+	onLost    func(context.Context, *kgo.Client, map[string][]int32)
+}
+
+// rebalanceOpts returns group options that count rebalance events and then run the callbacks.
+func (h *metricHooks) rebalanceOpts(callbacks rebalanceCallbacks) []kgo.Opt {
+	count := func(
+		event string,
+		next func(context.Context, *kgo.Client, map[string][]int32),
+	) func(context.Context, *kgo.Client, map[string][]int32) {
+		return func(ctx context.Context, cl *kgo.Client, partitions map[string][]int32) {
+			h.metrics.rebalances.WithLabelValues(h.group, event).Inc()
+
+			if next != nil {
+				next(ctx, cl, partitions)
+			}
+		}
+	}
+
+	return []kgo.Opt{
+		kgo.OnPartitionsAssigned(count("assigned", nil)),
+		kgo.OnPartitionsRevoked(count("revoked", callbacks.onRevoked)),
+		kgo.OnPartitionsLost(count("lost", callbacks.onLost)),
+	}
+}
+
+// handlerMetrics is the outermost middleware of the handlers of a client, it records
+// kafka_handler_duration_seconds and kafka_handler_errors_total of the group.
+func (h *metricHooks) handlerMetrics(next HandleFunc) HandleFunc {
+	return func(ctx context.Context, record *kgo.Record) error {
+		start := time.Now()
+
+		err := next(ctx, record)
+
+		h.metrics.handlerDuration.WithLabelValues(h.group, record.Topic).Observe(time.Since(start).Seconds())
+
+		if err != nil {
+			h.metrics.handlerErrors.WithLabelValues(h.group, record.Topic).Inc()
+		}
+
+		return err
+	}
+}
+
+// onAutoCommit counts the partitions whose offsets failed to be committed in the background.
+func (h *metricHooks) onAutoCommit(
+	_ *kgo.Client,
+	req *kmsg.OffsetCommitRequest,
+	resp *kmsg.OffsetCommitResponse,
+	err error,
+) {
+	if err != nil {
+		for _, topic := range req.Topics {
+			h.observeCommitFailure(topic.Topic)
+		}
+
+		logger.Errorf("error occurred when committing offsets: %v", err)
+
+		return
+	}
+
+	for _, topic := range resp.Topics {
+		for _, partition := range topic.Partitions {
+			if err = kerr.ErrorForCode(partition.ErrorCode); err != nil {
+				h.observeCommitFailure(topic.Topic)
+
+				logger.Errorf(
+					"error occurred when committing offset of topic %s, partition %d: %v",
+					topic.Topic, partition.Partition, err,
+				)
+			}
+		}
+	}
+}
+
+func (h *metricHooks) observeCommitFailure(topic string) {
+	h.metrics.commitFailures.WithLabelValues(h.group, topic).Inc()
+}
+
+func (h *metricHooks) observePaused(paused int) {
+	h.metrics.pausedPartitions.WithLabelValues(h.group).Set(float64(paused))
+}
+
+func (h *metricHooks) observeTransaction(committed bool) {
+	result := "aborted"
+	if committed {
+		result = "committed"
+	}
+
+	h.metrics.transactions.WithLabelValues(h.group, result).Inc()
+}
+
+// watchWorkers samples the worker pool saturation until ctx is done.
+func (h *metricHooks) watchWorkers(ctx context.Context, w *workers) {
+	const interval = time.Second
+
+	ticker := time.NewTicker(interval)
+
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			h.metrics.workerPoolActive.WithLabelValues(h.group).Set(float64(w.pool.Running()))
+			h.metrics.workerPoolQueued.WithLabelValues(h.group).Set(float64(w.waiting()))
+		}
+	}
+}
+
+type lagPartition struct {
+	topic     string
+	partition int32
+}
+
+// watchLag samples the lag of the partitions assigned to the client until ctx is done.
+func (h *metricHooks) watchLag(ctx context.Context, client *kgo.Client) {
+	const interval = 15 * time.Second
+
+	if h.group == "" {
+		return
+	}
+
+	var (
+		admin    = kadm.NewClient(client)
+		observed map[lagPartition]struct{}
+	)
+
+	ticker := time.NewTicker(interval)
+
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			memberID, _ := client.GroupMetadata()
+			observed = h.observeLag(ctx, admin, memberID, observed)
+		}
+	}
+}
+
+// observeLag sets the lag of the partitions assigned to the member from the committed and the end offsets,
+// and removes the partitions of observed it is no longer assigned. It returns the partitions it has set.
+func (h *metricHooks) observeLag(
+	ctx context.Context,
+	admin *kadm.Client,
+	memberID string,
+	observed map[lagPartition]struct{},
+) map[lagPartition]struct{} {
+	lags, err := admin.Lag(ctx, h.group)
+	if err == nil {
+		err = lags.Error()
+	}
+
+	if err != nil {
+		logger.Errorf("error occurred when computing the lag of group %s: %v", h.group, err)
+
+		return observed
+	}
+
+	assigned := make(map[lagPartition]struct{})
+
+	for topic, partitions := range lags[h.group].Lag {
+		for partition, lag := range partitions {
+			if lag.IsEmpty() || lag.Member.MemberID != memberID || lag.Err != nil {
+				continue
+			}
+
+			h.metrics.consumerLag.
+				WithLabelValues(h.group, topic, strconv.Itoa(int(partition))).
+				Set(float64(lag.Lag))
+
+			assigned[lagPartition{topic: topic, partition: partition}] = struct{}{}
+		}
+	}
+
+	for p := range observed {
+		if _, ok := assigned[p]; !ok {
+			h.metrics.consumerLag.DeleteLabelValues(h.group, p.topic, strconv.Itoa(int(p.partition)))
+		}
+	}
+
+	return assigned
+}
@@ -0,0 +1,57 @@
+package msg_queue
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/twmb/franz-go/pkg/kadm"
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+func TestObserveLagOfAssignedPartitions(t *testing.T) {
+	addrs := newTestCluster(t, 1, "orders")
+
+	client, err := kgo.NewClient(
+		kgo.SeedBrokers(addrs...),
+		kgo.ConsumerGroup(testGroup),
+		kgo.ConsumeTopics("orders"),
+		kgo.DisableAutoCommit(),
+		kgo.DefaultProduceTopic("orders"),
+	)
+	require.NoError(t, err)
+
+	defer client.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	for _, value := range []string{"a", "b", "c", "d", "e"} {
+		require.NoError(t, client.ProduceSync(ctx, &kgo.Record{Value: []byte(value)}).FirstErr())
+	}
+
+	var records []*kgo.Record
+	for len(records) < 5 {
+		fetches := client.PollFetches(ctx)
+		require.NoError(t, fetches.Err())
+
+		records = append(records, fetches.Records()...)
+	}
+
+	require.NoError(t, client.CommitRecords(ctx, records[1]))
+
+	h := newMetricHooks(testGroup)
+	h.metrics.consumerLag.WithLabelValues(testGroup, "revoked", "0").Set(7)
+
+	memberID, _ := client.GroupMetadata()
+	observed := h.observeLag(ctx, kadm.NewClient(client), memberID, map[lagPartition]struct{}{
+		{topic: "revoked", partition: 0}: {},
+	})
+
+	assert.Equal(t, map[lagPartition]struct{}{{topic: "orders", partition: 0}: {}}, observed)
+	assert.InDelta(t, 3, testutil.ToFloat64(h.metrics.consumerLag.WithLabelValues(testGroup, "orders", "0")), 0)
+	assert.False(t, h.metrics.consumerLag.DeleteLabelValues(testGroup, "revoked", "0"), "the revoked partition is removed")
+}
@@ -0,0 +1,246 @@
+package msg_queue
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"strconv"
+	"time"
+
+	"github.com/go-kratos/kratos/v2/log"
+	"github.com/pkg/errors"
+	"github.com/twmb/franz-go/pkg/kgo"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/protobuf/proto"
+)
+
+// Middleware is a consumer middleware, it mirrors kratos middleware.Middleware for kafka handlers.
+type Middleware = func(HandleFunc) HandleFunc
+
+// ErrPanic is returned by Recovery when the handler panics.
+var ErrPanic = errors.New("panic occurred in kafka handler")
+
+// Chain returns a Middleware that specifies the chained handler for consuming.
+func Chain(m ...Middleware) Middleware {
+	return func(next HandleFunc) HandleFunc {
+		for i := len(m) - 1; i >= 0; i-- {
+			next = m[i](next)
+		}
+
+		return next
+	}
+}
+
+// Recovery converts a handler panic into an error wrapping ErrPanic,
+// so the record goes to the dead letter topic instead of the process exiting. NewHandler applies it.
+func Recovery() Middleware {
+	return func(next HandleFunc) HandleFunc {
+		return func(ctx context.Context, record *kgo.Record) (err error) {
+			defer func() {
+				if rerr := recover(); rerr != nil {
+					buf := make([]byte, 64<<10)
+					buf = buf[:runtime.Stack(buf, false)]
+
+					log.Context(ctx).Errorf(
+						"%v: topic %s, partition %d, offset %d\n%s\n",
+						rerr, record.Topic, record.Partition, record.Offset, buf,
+					)
+
+					err = errors.Wrap(ErrPanic, fmt.Sprint(rerr))
+				}
+			}()
+
+			return next(ctx, record)
+		}
+	}
+}
+
+// Logging logs every handled record in the same format as kratos logging.Server.
+func Logging(logger log.Logger) Middleware {
+	return func(next HandleFunc) HandleFunc {
+		return func(ctx context.Context, record *kgo.Record) error {
+			startTime := time.Now()
+
+			err := next(ctx, record)
+
+			level, reason := log.LevelInfo, ""
+			if err != nil {
+				level, reason = log.LevelError, err.Error()
+			}
+
+			log.NewHelper(log.WithContext(ctx, logger)).Log(level,
+				"kind", "consumer",
+				"component", "kafka",
+				"operation", record.Topic,
+				"partition", record.Partition,
+				"offset", record.Offset,
+				"reason", reason,
+				"latency", time.Since(startTime).Seconds(),
+			)
+
+			return err
+		}
+	}
+}
+
+// Metrics records the duration and errors of the part of the chain it wraps by topic in
+// kafka_middleware_duration_seconds and kafka_middleware_errors_total, e.g. only the business logic
+// when it follows ProtoValidate, or the handlers of a MemoryClient. It is an addition to
+// kafka_handler_duration_seconds and kafka_handler_errors_total that the kafka clients always record.
+func Metrics() Middleware {
+	metrics := getKafkaMetrics()
+
+	return func(next HandleFunc) HandleFunc {
+		return func(ctx context.Context, record *kgo.Record) error {
+			startTime := time.Now()
+
+			err := next(ctx, record)
+
+			metrics.chainDuration.WithLabelValues(record.Topic).Observe(time.Since(startTime).Seconds())
+
+			if err != nil {
+				metrics.chainErrors.WithLabelValues(record.Topic).Inc()
+			}
+
+			return err
+		}
+	}
+}
+
+// Timeout cancels the handler context after the given duration.
+func Timeout(timeout time.Duration) Middleware {
+	return func(next HandleFunc) HandleFunc {
+		return func(ctx context.Context, record *kgo.Record) error {
+			ctx, cancel := context.WithTimeout(ctx, timeout)
+			defer cancel()
+
+			return next(ctx, record)
+		}
+	}
+}
+
+// Tracing starts a consumer span that continues the trace propagated in the record headers.
+func Tracing() Middleware {
+	tracer := otel.Tracer("platform/msg_queue")
+
+	return func(next HandleFunc) HandleFunc {
+		return func(ctx context.Context, record *kgo.Record) error {
+			ctx = otel.GetTextMapPropagator().Extract(ctx, recordCarrier{record: record})
+
+			ctx, span := tracer.Start(
+				ctx,
+				record.Topic+" process",
+				trace.WithSpanKind(trace.SpanKindConsumer),
+				trace.WithAttributes(
+					attribute.String("messaging.system", "kafka"),
+					attribute.String("messaging.destination.name", record.Topic),
+					attribute.Int("messaging.destination.partition.id", int(record.Partition)),
+					attribute.Int64("messaging.kafka.offset", record.Offset),
+				),
+			)
+			defer span.End()
+
+			err := next(ctx, record)
+			if err != nil {
+				span.RecordError(err)
+				span.SetStatus(codes.Error, err.Error())
+			}
+
+			return err
+		}
+	}
+}
+
+type validator interface {
+	Validate() error
+}
+
+type messageKey struct{}
+
+// ProtoValidate unmarshals the record value into a message created by newMessage and calls
+// its generated Validate method. The decoded message is available to the handler via Message.
+func ProtoValidate(newMessage func() proto.Message) Middleware {
+	return func(next HandleFunc) HandleFunc {
+		return func(ctx context.Context, record *kgo.Record) error {
+			msg := newMessage()
+
+			if err := proto.Unmarshal(record.Value, msg); err != nil {
+				return errors.Wrap(err, "error occurred when unmarshalling a record")
+			}
+
+			if v, ok := msg.(validator); ok {
+				if err := v.Validate(); err != nil {
+					return errors.Wrap(err, "record validation failed")
+				}
+			}
+
+			return next(context.WithValue(ctx, messageKey{}, msg), record)
+		}
+	}
+}
+
+// Message returns the message decoded by ProtoValidate.
+func Message[T proto.Message](ctx context.Context) (T, bool) {
+	msg, ok := ctx.Value(messageKey{}).(T)
+
+	return msg, ok
+}
+
+// recordCarrier adapts record headers to propagation.TextMapCarrier.
+type recordCarrier struct {
+	record *kgo.Record
+}
+
+func (c recordCarrier) Get(key string) string {
+	for _, h := range c.record.Headers {
+		if h.Key == key {
+			return string(h.Value)
+		}
+	}
+
+	return ""
+}
+
+func (c recordCarrier) Set(key string, value string) {
+	for i, h := range c.record.Headers {
+		if h.Key == key {
+			c.record.Headers[i].Value = []byte(value)
+
+			return
+		}
+	}
+
+	c.record.Headers = append(c.record.Headers, kgo.RecordHeader{Key: key, Value: []byte(value)})
+}
+
+func (c recordCarrier) Keys() []string {
+	keys := make([]string, 0, len(c.record.Headers))
+
+	for _, h := range c.record.Headers {
+		keys = append(keys, h.Key)
+	}
+
+	return keys
+}
+
+// deadLetterRecord copies the record for the dead letter topic, keeping where it came from and why.
+func deadLetterRecord(topic string, record *kgo.Record, err error) *kgo.Record {
+	headers := make([]kgo.RecordHeader, 0, len(record.Headers)+4)
+	headers = append(headers, record.Headers...)
+	headers = append(headers,
+		kgo.RecordHeader{Key: "dlq.topic", Value: []byte(record.Topic)},
+		kgo.RecordHeader{Key: "dlq.partition", Value: []byte(strconv.Itoa(int(record.Partition)))},
+		kgo.RecordHeader{Key: "dlq.offset", Value: []byte(strconv.FormatInt(record.Offset, 10))},
+		kgo.RecordHeader{Key: "dlq.error", Value: []byte(err.Error())},
+	)
+
+	return &kgo.Record{
+		Topic:   topic,
+		Key:     record.Key,
+		Value:   record.Value,
+		Headers: headers,
+	}
+}
@@ -0,0 +1,158 @@
+package msg_queue
+
+import (
+	"sync"
+
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+// offsetTracker tracks the records handed to the handlers by partition, so an offset is committed
+// only when every record before it is acknowledged: a nacked or slow record holds back the commit
+// of its partition instead of being skipped by the commit of a later one.
+// Records are identified by pointer, a record delivered again after a rebalance is a new one.
+type offsetTracker struct {
+	mu         sync.Mutex
+	partitions map[topicPartition]*partitionOffsets
+}
+
+type partitionOffsets struct {
+	// queue holds the records that are not committable yet in offset order.
+	queue   []*kgo.Record
+	records map[*kgo.Record]*trackedRecord
+}
+
+type trackedRecord struct {
+	isDone   bool
+	attempts int
+}
+
+func newOffsetTracker() *offsetTracker {
+	return &offsetTracker{
+		partitions: make(map[topicPartition]*partitionOffsets),
+	}
+}
+
+// track is called in offset order within a partition, before the record is handed to a handler.
+func (t *offsetTracker) track(record *kgo.Record) {
+	tp := topicPartition{topic: record.Topic, partition: record.Partition}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	p, ok := t.partitions[tp]
+	if !ok {
+		p = &partitionOffsets{records: make(map[*kgo.Record]*trackedRecord)}
+		t.partitions[tp] = p
+	}
+
+	p.queue = append(p.queue, record)
+	p.records[record] = &trackedRecord{}
+}
+
+// done marks the record as acknowledged and returns the last record of the acknowledged prefix
+// of its partition, whose offset can be committed, and the number of records that left the tracker,
+// zero if the prefix has not moved.
+func (t *offsetTracker) done(record *kgo.Record) (*kgo.Record, int) {
+	tp := topicPartition{topic: record.Topic, partition: record.Partition}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	p, ok := t.partitions[tp]
+	if !ok {
+		return nil, 0
+	}
+
+	tracked, ok := p.records[record]
+	if !ok {
+		return nil, 0
+	}
+
+	tracked.isDone = true
+
+	var (
+		commit *kgo.Record
+		n      int
+	)
+
+	for len(p.queue) > 0 && p.records[p.queue[0]].isDone {
+		commit = p.queue[0]
+		delete(p.records, commit)
+		p.queue = p.queue[1:]
+		n++
+	}
+
+	if len(p.queue) == 0 {
+		delete(t.partitions, tp)
+	}
+
+	return commit, n
+}
+
+// retry counts a failed delivery of the record and returns the number of failures so far,
+// or false if the record is not tracked anymore, e.g. its partition was revoked.
+func (t *offsetTracker) retry(record *kgo.Record) (int, bool) {
+	tp := topicPartition{topic: record.Topic, partition: record.Partition}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	p, ok := t.partitions[tp]
+	if !ok {
+		return 0, false
+	}
+
+	tracked, ok := p.records[record]
+	if !ok {
+		return 0, false
+	}
+
+	tracked.attempts++
+
+	return tracked.attempts, true
+}
+
+// isTracked reports whether the record is still waiting for its acknowledgment.
+func (t *offsetTracker) isTracked(record *kgo.Record) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	p, ok := t.partitions[topicPartition{topic: record.Topic, partition: record.Partition}]
+	if !ok {
+		return false
+	}
+
+	_, ok = p.records[record]
+
+	return ok
+}
+
+// forget drops the records of the partitions, their acknowledgments do not commit anything anymore.
+// It returns the number of dropped records by partition.
+func (t *offsetTracker) forget(partitions map[string][]int32) map[topicPartition]int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	dropped := make(map[topicPartition]int)
+
+	for topic, ids := range partitions {
+		for _, id := range ids {
+			tp := topicPartition{topic: topic, partition: id}
+
+			if p, ok := t.partitions[tp]; ok {
+				dropped[tp] = len(p.queue)
+				delete(t.partitions, tp)
+			}
+		}
+	}
+
+	return dropped
+}
+
+// forgetAll drops the records of every partition.
+func (t *offsetTracker) forgetAll() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	clear(t.partitions)
+}
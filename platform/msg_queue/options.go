@@ -0,0 +1,267 @@
+package msg_queue
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"os"
+	"time"
+
+	"github.com/caarlos0/env/v11"
+	"github.com/goccy/go-json"
+	"github.com/pkg/errors"
+	"github.com/twmb/franz-go/pkg/kgo"
+	"github.com/twmb/franz-go/pkg/sasl/plain"
+	"github.com/twmb/franz-go/pkg/sasl/scram"
+)
+
+type SASLMechanism string
+
+const (
+	SASLNone        SASLMechanism = "none"
+	SASLPlain       SASLMechanism = "plain"
+	SASLScramSHA256 SASLMechanism = "scram-sha-256"
+	SASLScramSHA512 SASLMechanism = "scram-sha-512"
+)
+
+// KafkaTLSOptions enables TLS to the brokers. CAFile verifies the brokers instead of the system pool,
+// CertFile and KeyFile authenticate the client (mTLS).
+type KafkaTLSOptions struct {
+	Enabled  bool
+	CAFile   string
+	CertFile string
+	KeyFile  string
+}
+
+// KafkaOptions configures kafka clients. LoadKafkaOptions fills it from the environment.
+type KafkaOptions struct {
+	Addrs []string
+	// Topics are consumed by the clients created with a HandlerTable, every topic must be in the table.
+	Topics []string
+	Group  string
+
+	// SASL is the authentication mechanism; empty means SASLPlain if User is set and SASLNone otherwise.
+	SASL     SASLMechanism
+	User     string
+	Password string
+	TLS      KafkaTLSOptions
+
+	// MaxPollRecords limits the records handed to the workers by one poll, zero means 500.
+	MaxPollRecords int
+	// PartitionHighWatermark is the number of records in flight that pauses fetching a partition,
+	// zero means 1000. PartitionLowWatermark resumes it, zero means half of the high watermark.
+	PartitionHighWatermark int
+	PartitionLowWatermark  int
+}
+
+type kafkaConnConfig struct {
+	Addrs         string `env:"KAFKA_ADDRS, required, notEmpty"`
+	SASLMechanism string `env:"KAFKA_SASL_MECHANISM"`
+	User          string `env:"KAFKA_USER"`
+	Password      string `env:"KAFKA_PASSWORD"`
+	TLSEnabled    bool   `env:"KAFKA_TLS_ENABLED"`
+	TLSCAFile     string `env:"KAFKA_TLS_CA_FILE"`
+	TLSCertFile   string `env:"KAFKA_TLS_CERT_FILE"`
+	TLSKeyFile    string `env:"KAFKA_TLS_KEY_FILE"`
+}
+
+type kafkaConfig struct {
+	kafkaConnConfig
+
+	Topics string `env:"KAFKA_TOPICS" envDefault:"[]"`
+	Group  string `env:"KAFKA_GROUP"`
+
+	MaxPollRecords         int `env:"KAFKA_MAX_POLL_RECORDS"`
+	PartitionHighWatermark int `env:"KAFKA_PARTITION_HIGH_WATERMARK"`
+	PartitionLowWatermark  int `env:"KAFKA_PARTITION_LOW_WATERMARK"`
+}
+
+// LoadKafkaOptions reads KafkaOptions from the environment:
+// KAFKA_ADDRS and KAFKA_TOPICS are JSON arrays, KAFKA_GROUP is the consumer group,
+// KAFKA_SASL_MECHANISM is one of none, plain, scram-sha-256, scram-sha-512 with KAFKA_USER and KAFKA_PASSWORD,
+// KAFKA_TLS_ENABLED, KAFKA_TLS_CA_FILE, KAFKA_TLS_CERT_FILE and KAFKA_TLS_KEY_FILE configure TLS,
+// KAFKA_MAX_POLL_RECORDS, KAFKA_PARTITION_HIGH_WATERMARK and KAFKA_PARTITION_LOW_WATERMARK configure flow control.
+func LoadKafkaOptions() (KafkaOptions, error) {
+	cfg, err := env.ParseAs[kafkaConfig]()
+	if err != nil {
+		return KafkaOptions{}, err
+	}
+
+	var opts KafkaOptions
+
+	if err = json.Unmarshal([]byte(cfg.Addrs), &opts.Addrs); err != nil {
+		return KafkaOptions{}, errors.Wrap(err, "error occurred when unmarshalling kafka Addrs")
+	}
+
+	if err = json.Unmarshal([]byte(cfg.Topics), &opts.Topics); err != nil {
+		return KafkaOptions{}, errors.Wrap(err, "error occurred when unmarshalling kafka Topics")
+	}
+
+	opts.Group = cfg.Group
+	opts.SASL = SASLMechanism(cfg.SASLMechanism)
+	opts.User = cfg.User
+	opts.Password = cfg.Password
+	opts.TLS = KafkaTLSOptions{
+		Enabled:  cfg.TLSEnabled,
+		CAFile:   cfg.TLSCAFile,
+		CertFile: cfg.TLSCertFile,
+		KeyFile:  cfg.TLSKeyFile,
+	}
+	opts.MaxPollRecords = cfg.MaxPollRecords
+	opts.PartitionHighWatermark = cfg.PartitionHighWatermark
+	opts.PartitionLowWatermark = cfg.PartitionLowWatermark
+
+	return opts, nil
+}
+
+// flowLimits returns the max poll records and the partition watermarks with the defaults applied.
+func (o KafkaOptions) flowLimits() (maxPollRecords, high, low int, err error) {
+	maxPollRecords, high, low = o.MaxPollRecords, o.PartitionHighWatermark, o.PartitionLowWatermark
+
+	if maxPollRecords == 0 {
+		maxPollRecords = 500
+	}
+
+	if high == 0 {
+		high = 1000
+	}
+
+	if low == 0 {
+		low = high / 2
+	}
+
+	if maxPollRecords < 0 || high < 0 || low < 0 || low >= high {
+		return 0, 0, 0, errors.Errorf(
+			"invalid kafka flow control: max poll records %d, partition watermarks %d/%d",
+			maxPollRecords, high, low,
+		)
+	}
+
+	return maxPollRecords, high, low, nil
+}
+
+// connOpts returns the options to connect to the cluster.
+func (o KafkaOptions) connOpts() ([]kgo.Opt, error) {
+	if len(o.Addrs) == 0 {
+		return nil, errors.New("error occurred when creating a kafka client: empty address")
+	}
+
+	opts := []kgo.Opt{
+		kgo.SeedBrokers(o.Addrs...),
+	}
+
+	mechanism := o.SASL
+	if mechanism == "" {
+		mechanism = SASLNone
+		if o.User != "" {
+			mechanism = SASLPlain
+		}
+	}
+
+	if mechanism != SASLNone && (o.User == "" || o.Password == "") {
+		return nil, errors.Errorf("kafka user and password are required for SASL mechanism %s", mechanism)
+	}
+
+	switch mechanism {
+	case SASLNone:
+	case SASLPlain:
+		opts = append(opts, kgo.SASL(plain.Auth{
+			User: o.User,
+			Pass: o.Password,
+		}.AsMechanism()))
+	case SASLScramSHA256:
+		opts = append(opts, kgo.SASL(scram.Auth{
+			User: o.User,
+			Pass: o.Password,
+		}.AsSha256Mechanism()))
+	case SASLScramSHA512:
+		opts = append(opts, kgo.SASL(scram.Auth{
+			User: o.User,
+			Pass: o.Password,
+		}.AsSha512Mechanism()))
+	default:
+		return nil, errors.Errorf("unknown kafka SASL mechanism: %s", mechanism)
+	}
+
+	if o.TLS.Enabled {
+		tlsCfg, err := o.TLS.config()
+		if err != nil {
+			return nil, err
+		}
+
+		opts = append(opts, kgo.DialTLSConfig(tlsCfg))
+	}
+
+	return opts, nil
+}
+
+func (o KafkaTLSOptions) config() (*tls.Config, error) {
+	cfg := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+	}
+
+	if o.CAFile != "" {
+		ca, err := os.ReadFile(o.CAFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "error occurred when reading kafka CA file")
+		}
+
+		cfg.RootCAs = x509.NewCertPool()
+		if !cfg.RootCAs.AppendCertsFromPEM(ca) {
+			return nil, errors.New("kafka CA file contains no certificates")
+		}
+	}
+
+	if o.CertFile != "" || o.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(o.CertFile, o.KeyFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "error occurred when loading kafka client certificate")
+		}
+
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+
+	return cfg, nil
+}
+
+// clientOpts returns the options shared by every kafka client of the group, the commit mode is up to the client.
+func (o KafkaOptions) clientOpts(metrics *metricHooks, callbacks rebalanceCallbacks) ([]kgo.Opt, error) {
+	if o.Group == "" {
+		return nil, errors.New("error occurred when creating a kafka client: empty group")
+	}
+
+	opts, err := o.connOpts()
+	if err != nil {
+		return nil, err
+	}
+
+	opts = append(
+		opts,
+		kgo.ConsumerGroup(o.Group),
+		kgo.FetchMinBytes(1<<10),
+		kgo.FetchMaxBytes(4<<20),
+		kgo.FetchMaxWait(10*time.Millisecond),
+		kgo.SessionTimeout(30*time.Second),
+		kgo.WithHooks(metrics),
+	)
+
+	return append(opts, metrics.rebalanceOpts(callbacks)...), nil
+}
+
+// subscribedTable returns the handlers of the configured topics.
+func (o KafkaOptions) subscribedTable(table HandlerTable) (HandlerTable, error) {
+	subscribed := make(HandlerTable, len(o.Topics))
+
+	for _, topic := range o.Topics {
+		handler, ok := table[topic]
+		if !ok {
+			return nil, errors.Errorf(
+				"error occurred when creating a kafka client: topic %s is not in the handle table",
+				topic,
+			)
+		}
+
+		subscribed[topic] = handler
+	}
+
+	return subscribed, nil
+}
@@ -0,0 +1,84 @@
+package msg_queue
+
+import (
+	"context"
+
+	"platform/logger"
+	"platform/tracing"
+
+	"github.com/pkg/errors"
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+// KafkaProducer is a Publisher of kafka that joins no consumer group, for the services that only produce.
+type KafkaProducer struct {
+	client *kgo.Client
+}
+
+var _ Publisher = (*KafkaProducer)(nil)
+
+// MustCreateKafkaProducer creates a KafkaProducer for the cluster configured by the environment
+// (see LoadKafkaOptions), KAFKA_GROUP and KAFKA_TOPICS are not needed.
+func MustCreateKafkaProducer() *KafkaProducer {
+	opts, err := LoadKafkaOptions()
+	if err != nil {
+		logger.Fatal(err.Error())
+
+		return nil
+	}
+
+	producer, err := NewKafkaProducer(opts)
+	if err != nil {
+		logger.Fatal(err.Error())
+
+		return nil
+	}
+
+	return producer
+}
+
+// NewKafkaProducer creates a producer for the cluster, only the connection options are used.
+func NewKafkaProducer(opts KafkaOptions) (*KafkaProducer, error) {
+	kgoOpts, err := opts.connOpts()
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := kgo.NewClient(append(kgoOpts, kgo.WithHooks(newMetricHooks("")))...)
+	if err != nil {
+		return nil, errors.Wrap(err, "error occurred when creating a kafka client")
+	}
+
+	return &KafkaProducer{client: client}, nil
+}
+
+func (p *KafkaProducer) Produce(ctx context.Context, topic string, value []byte) error {
+	return p.ProduceRecord(ctx, &kgo.Record{
+		Topic: topic,
+		Value: value,
+	})
+}
+
+func (p *KafkaProducer) ProduceRecord(ctx context.Context, record *kgo.Record) error {
+	return produceSync(ctx, p.client, record)
+}
+
+func (p *KafkaProducer) Close() {
+	p.client.Close()
+}
+
+// produceSync produces the record with the request ID and a producer span.
+func produceSync(ctx context.Context, client *kgo.Client, record *kgo.Record) error {
+	withRequestID(ctx, record)
+
+	ctx, span := startProducerSpan(ctx, record)
+
+	err := client.ProduceSync(ctx, record).FirstErr()
+	if err != nil {
+		err = errors.Wrap(err, "error occurred when producing a record")
+	}
+
+	tracing.EndSpan(span, err)
+
+	return err
+}
@@ -0,0 +1,37 @@
+package msg_queue
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+func TestKafkaProducerNeedsNoGroup(t *testing.T) {
+	addrs := newTestCluster(t, 1, "audit")
+
+	producer, err := NewKafkaProducer(KafkaOptions{Addrs: addrs})
+	require.NoError(t, err)
+
+	defer producer.Close()
+
+	require.NoError(t, producer.Produce(context.Background(), "audit", []byte("record")))
+
+	consumer, err := kgo.NewClient(kgo.SeedBrokers(addrs...), kgo.ConsumeTopics("audit"))
+	require.NoError(t, err)
+
+	defer consumer.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	fetches := consumer.PollRecords(ctx, 1)
+	require.NoError(t, fetches.Err())
+
+	records := fetches.Records()
+	require.Len(t, records, 1)
+	assert.Equal(t, []byte("record"), records[0].Value)
+}
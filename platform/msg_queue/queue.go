@@ -0,0 +1,161 @@
+package msg_queue
+
+import (
+	"context"
+
+	"platform/logger"
+
+	"github.com/caarlos0/env/v11"
+	"github.com/pkg/errors"
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+// ErrAlreadySubscribed is returned when Subscribe is called twice on the same Subscriber.
+var ErrAlreadySubscribed = errors.New("subscriber is already subscribed")
+
+// Publisher produces records to a broker. Records are represented by kgo.Record for every broker,
+// only Topic, Key, Value and Headers are read from it.
+type Publisher interface {
+	Produce(ctx context.Context, topic string, value []byte) error
+	ProduceRecord(ctx context.Context, record *kgo.Record) error
+	Close()
+}
+
+// Subscriber consumes the topics of a HandlerTable within a consumer group.
+//
+// Delivery is at-least-once: a record is acknowledged after its handler returns nil
+// (or before the handler runs if the Handler is created with AckBeforeProcessing).
+// When a handler returns an error, the record is produced to the dead letter topic of the Handler
+// and acknowledged, or, if there is no dead letter topic, nacked: it is handed to the handler again
+// after a delay for as long as the subscriber owns its partition.
+//
+// The offset of a partition is committed only up to its first record that is not acknowledged,
+// so a nacked record also holds back the commit of the records after it, and after a rebalance
+// or a restart the consumption resumes from it.
+type Subscriber interface {
+	Subscribe(table HandlerTable) error
+	Close()
+}
+
+type MessageQueue interface {
+	Publisher
+	Subscriber
+}
+
+type queueConfig struct {
+	Driver string `env:"MESSAGE_QUEUE_DRIVER" envDefault:"kafka"`
+}
+
+// MustCreateMainMessageQueue creates a MessageQueue chosen by MESSAGE_QUEUE_DRIVER:
+// "kafka" (default) or "memory" for the in-process broker.
+func MustCreateMainMessageQueue(table HandlerTable, maxGoroutines uint) MessageQueue {
+	cfg, err := env.ParseAs[queueConfig]()
+	if err != nil {
+		logger.Fatal(err.Error())
+
+		return nil
+	}
+
+	switch cfg.Driver {
+	case "kafka":
+		return MustCreateKafkaClient(table, maxGoroutines)
+	case "memory":
+		client := DefaultMemoryBroker().Client("main", maxGoroutines)
+
+		if len(table) > 0 {
+			if err = client.Subscribe(table); err != nil {
+				logger.Fatal(err.Error())
+			}
+		}
+
+		return client
+	default:
+		logger.Fatalf("unknown message queue driver: %s", cfg.Driver)
+
+		return nil
+	}
+}
+
+// MustCreateMainPublisher creates a Publisher chosen by MESSAGE_QUEUE_DRIVER like MustCreateMainMessageQueue,
+// it joins no consumer group.
+func MustCreateMainPublisher() Publisher {
+	cfg, err := env.ParseAs[queueConfig]()
+	if err != nil {
+		logger.Fatal(err.Error())
+
+		return nil
+	}
+
+	switch cfg.Driver {
+	case "kafka":
+		return MustCreateKafkaProducer()
+	case "memory":
+		return DefaultMemoryBroker().Client("main", 0)
+	default:
+		logger.Fatalf("unknown message queue driver: %s", cfg.Driver)
+
+		return nil
+	}
+}
+
+// process runs the handler with the acknowledgment semantics described in Subscriber.
+func (h Handler) process(
+	ctx context.Context,
+	publisher Publisher,
+	record *kgo.Record,
+	ack func(*kgo.Record),
+	nack func(*kgo.Record),
+) {
+	ctx = contextWithRequestID(ctx, record)
+
+	// The handler itself is recovered (see NewHandler), a panic of the middlewares or the dead letter
+	// producer leaves the record to be handled again.
+	defer func() {
+		if rerr := recover(); rerr != nil {
+			logger.WithContext(ctx).Errorf(
+				"panic occurred when processing a record from topic %s, partition %d, offset %d: %v",
+				record.Topic, record.Partition, record.Offset, rerr,
+			)
+
+			nack(record)
+		}
+	}()
+
+	if h.isAckBeforeProcessing {
+		ack(record)
+	}
+
+	err := h.fn(ctx, record)
+	if err == nil {
+		if !h.isAckBeforeProcessing {
+			ack(record)
+		}
+
+		return
+	}
+
+	logger.WithContext(ctx).Errorf(
+		"error occurred when handling a record from topic %s, partition %d, offset %d: %v",
+		record.Topic, record.Partition, record.Offset, err,
+	)
+
+	if h.isAckBeforeProcessing {
+		return
+	}
+
+	if h.deadLetterTopic == "" {
+		nack(record)
+
+		return
+	}
+
+	if err = publisher.ProduceRecord(ctx, deadLetterRecord(h.deadLetterTopic, record, err)); err != nil {
+		logger.Errorf("error occurred when producing a record to the dead letter topic: %v", err)
+
+		nack(record)
+
+		return
+	}
+
+	ack(record)
+}
@@ -0,0 +1,261 @@
+package msg_queue
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/twmb/franz-go/pkg/kadm"
+)
+
+type OffsetResetKind string
+
+const (
+	ResetEarliest  OffsetResetKind = "earliest"
+	ResetLatest    OffsetResetKind = "latest"
+	ResetTimestamp OffsetResetKind = "timestamp"
+	ResetOffset    OffsetResetKind = "offset"
+)
+
+// OffsetReset is the position ResetGroupOffsets moves a consumer group to.
+type OffsetReset struct {
+	Kind OffsetResetKind
+	// Timestamp is used by ResetTimestamp, the group resumes from the first record at or after it.
+	Timestamp time.Time
+	// Offset is used by ResetOffset, it is clamped to the offsets available in every partition.
+	Offset int64
+}
+
+// ParseOffsetReset parses "earliest", "latest", an RFC 3339 timestamp or an offset.
+func ParseOffsetReset(s string) (OffsetReset, error) {
+	switch OffsetResetKind(s) {
+	case ResetEarliest, ResetLatest:
+		return OffsetReset{Kind: OffsetResetKind(s)}, nil
+	}
+
+	if ts, err := time.Parse(time.RFC3339, s); err == nil {
+		return OffsetReset{Kind: ResetTimestamp, Timestamp: ts}, nil
+	}
+
+	offset, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || offset < 0 {
+		return OffsetReset{}, errors.Errorf(
+			"invalid offset reset %q: want earliest, latest, an RFC 3339 timestamp or an offset",
+			s,
+		)
+	}
+
+	return OffsetReset{Kind: ResetOffset, Offset: offset}, nil
+}
+
+// OffsetChange is a committed offset moved by ResetGroupOffsets.
+type OffsetChange struct {
+	Topic     string
+	Partition int32
+	// From is the committed offset before the reset, -1 if the group has not committed the partition.
+	From    int64
+	To      int64
+	Applied bool
+}
+
+func (c OffsetChange) String() string {
+	status := "dry run"
+	if c.Applied {
+		status = "applied"
+	}
+
+	return fmt.Sprintf("%s/%d: %d -> %d (%s)", c.Topic, c.Partition, c.From, c.To, status)
+}
+
+// ResetGroupOffsets moves the committed offsets of the group on the given partitions to reset
+// and returns the changes. A topic without partitions means all of its partitions.
+// The group must have no active members, with dryRun nothing is committed.
+func ResetGroupOffsets(
+	ctx context.Context,
+	admin *kadm.Client,
+	group string,
+	topics map[string][]int32,
+	reset OffsetReset,
+	dryRun bool,
+) ([]OffsetChange, error) {
+	if len(topics) == 0 {
+		return nil, errors.New("error occurred when resetting offsets: no topics")
+	}
+
+	names := slices.Sorted(maps.Keys(topics))
+
+	if !dryRun {
+		if err := checkGroupInactive(ctx, admin, group); err != nil {
+			return nil, err
+		}
+	}
+
+	start, err := admin.ListStartOffsets(ctx, names...)
+	if err == nil {
+		err = start.Error()
+	}
+
+	if err != nil {
+		return nil, errors.Wrap(err, "error occurred when listing kafka start offsets")
+	}
+
+	end, err := admin.ListEndOffsets(ctx, names...)
+	if err == nil {
+		err = end.Error()
+	}
+
+	if err != nil {
+		return nil, errors.Wrap(err, "error occurred when listing kafka end offsets")
+	}
+
+	var after kadm.ListedOffsets
+
+	switch reset.Kind {
+	case ResetEarliest, ResetLatest, ResetOffset:
+	case ResetTimestamp:
+		after, err = admin.ListOffsetsAfterMilli(ctx, reset.Timestamp.UnixMilli(), names...)
+		if err == nil {
+			err = after.Error()
+		}
+
+		if err != nil {
+			return nil, errors.Wrap(err, "error occurred when listing kafka offsets by timestamp")
+		}
+	default:
+		return nil, errors.Errorf("unknown offset reset kind: %s", reset.Kind)
+	}
+
+	committed, err := admin.FetchOffsetsForTopics(ctx, group, names...)
+	if err == nil {
+		err = committed.Error()
+	}
+
+	if err != nil {
+		return nil, errors.Wrapf(err, "error occurred when fetching offsets of group %s", group)
+	}
+
+	var (
+		changes []OffsetChange
+		offsets kadm.Offsets
+	)
+
+	for _, topic := range names {
+		partitions := topics[topic]
+		if len(partitions) == 0 {
+			partitions = slices.Sorted(maps.Keys(end[topic]))
+		}
+
+		for _, partition := range partitions {
+			last, ok := end.Lookup(topic, partition)
+			if !ok {
+				return nil, errors.Errorf("error occurred when resetting offsets: no partition %s/%d", topic, partition)
+			}
+
+			first, _ := start.Lookup(topic, partition)
+
+			var to int64
+
+			switch reset.Kind {
+			case ResetEarliest:
+				to = first.Offset
+			case ResetLatest:
+				to = last.Offset
+			case ResetTimestamp:
+				listed, _ := after.Lookup(topic, partition)
+				to = listed.Offset
+			case ResetOffset:
+				to = min(max(reset.Offset, first.Offset), last.Offset)
+			}
+
+			from := int64(-1)
+			if current, ok := committed.Lookup(topic, partition); ok {
+				from = current.At
+			}
+
+			changes = append(changes, OffsetChange{
+				Topic:     topic,
+				Partition: partition,
+				From:      from,
+				To:        to,
+			})
+			offsets.AddOffset(topic, partition, to, -1)
+		}
+	}
+
+	if dryRun {
+		return changes, nil
+	}
+
+	if err = admin.CommitAllOffsets(ctx, group, offsets); err != nil {
+		return nil, errors.Wrapf(err, "error occurred when committing offsets of group %s", group)
+	}
+
+	for i := range changes {
+		changes[i].Applied = true
+	}
+
+	return changes, nil
+}
+
+// checkGroupInactive returns an error if the group has members, kafka rejects their offsets otherwise.
+func checkGroupInactive(ctx context.Context, admin *kadm.Client, group string) error {
+	described, err := admin.DescribeGroups(ctx, group)
+	if err == nil {
+		err = described.Error()
+	}
+
+	if err != nil {
+		return errors.Wrapf(err, "error occurred when describing group %s", group)
+	}
+
+	if members := len(described[group].Members); members > 0 {
+		return errors.Errorf(
+			"group %s has %d active members, stop its consumers before resetting offsets",
+			group, members,
+		)
+	}
+
+	return nil
+}
+
+// Replay resets sideGroup on opts.Topics and consumes them with the table in sideGroup,
+// so the records are handled again without moving the offsets of opts.Group.
+// The returned client consumes until it is closed.
+func Replay(
+	ctx context.Context,
+	opts KafkaOptions,
+	sideGroup string,
+	reset OffsetReset,
+	table HandlerTable,
+	maxGoroutines uint,
+) (*KGOClient, error) {
+	if sideGroup == "" || sideGroup == opts.Group {
+		return nil, errors.Errorf("error occurred when replaying: side group %q must differ from %q", sideGroup, opts.Group)
+	}
+
+	admin, err := NewKafkaAdmin(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	topics := make(map[string][]int32, len(opts.Topics))
+	for _, topic := range opts.Topics {
+		topics[topic] = nil
+	}
+
+	_, err = ResetGroupOffsets(ctx, admin, sideGroup, topics, reset, false)
+
+	admin.Close()
+
+	if err != nil {
+		return nil, err
+	}
+
+	opts.Group = sideGroup
+
+	return NewKafkaClient(opts, table, maxGoroutines)
+}
@@ -0,0 +1,38 @@
+package msg_queue
+
+import (
+	"context"
+	"slices"
+
+	"platform/requestid"
+
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+// withRequestID adds the request ID of ctx to the record headers unless the record already has one,
+// so the consumers log the ID of the request that produced the record.
+func withRequestID(ctx context.Context, record *kgo.Record) {
+	id := requestid.FromContext(ctx)
+	if id == "" {
+		return
+	}
+
+	if slices.ContainsFunc(record.Headers, func(header kgo.RecordHeader) bool {
+		return header.Key == requestid.Header
+	}) {
+		return
+	}
+
+	record.Headers = append(record.Headers, kgo.RecordHeader{Key: requestid.Header, Value: []byte(id)})
+}
+
+// contextWithRequestID returns ctx with the request ID of the record or with a new one.
+func contextWithRequestID(ctx context.Context, record *kgo.Record) context.Context {
+	for _, header := range record.Headers {
+		if header.Key == requestid.Header && requestid.IsValid(string(header.Value)) {
+			return requestid.NewContext(ctx, string(header.Value))
+		}
+	}
+
+	return requestid.NewContext(ctx, requestid.New())
+}
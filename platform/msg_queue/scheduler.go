@@ -0,0 +1,169 @@
+package msg_queue
+
+import (
+	"context"
+	"time"
+
+	"platform/logger"
+	"platform/requestid"
+
+	"github.com/caarlos0/env/v11"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/pkg/errors"
+)
+
+type schedulerConfig struct {
+	PollInterval time.Duration `env:"SCHEDULER_POLL_INTERVAL" envDefault:"1s"`
+	BatchSize    int           `env:"SCHEDULER_BATCH_SIZE" envDefault:"100"`
+}
+
+// Scheduler stores records that must not be handled before a given time in Postgres
+// and re-publishes them to their topics when they are due.
+//
+// Several replicas may run the dispatcher concurrently, due records are locked with SKIP LOCKED.
+// Delivery is at-least-once: a record is published again if the deletion after publishing fails.
+type Scheduler struct {
+	pool      *pgxpool.Pool
+	publisher Publisher
+	cfg       schedulerConfig
+	metrics   *kafkaMetrics
+}
+
+// MustCreateScheduler runs the dispatcher until ctx is done.
+// The scheduled_messages table is created by the migrations, see postgres_pool.MustMigrate.
+func MustCreateScheduler(ctx context.Context, pool *pgxpool.Pool, publisher Publisher) *Scheduler {
+	cfg, err := env.ParseAs[schedulerConfig]()
+	if err != nil {
+		logger.Fatal(err.Error())
+
+		return nil
+	}
+
+	s := &Scheduler{
+		pool:      pool,
+		publisher: publisher,
+		cfg:       cfg,
+		metrics:   getKafkaMetrics(),
+	}
+
+	go s.startDispatching(ctx)
+
+	return s
+}
+
+// ProduceAt schedules the value to be produced to the topic no earlier than deliverAt.
+func (s *Scheduler) ProduceAt(ctx context.Context, topic string, deliverAt time.Time, value []byte) error {
+	if _, err := s.pool.Exec(
+		ctx,
+		"INSERT INTO scheduled_messages (topic, value, deliver_at, request_id) VALUES ($1, $2, $3, $4)",
+		topic, value, deliverAt, requestid.FromContext(ctx),
+	); err != nil {
+		return errors.Wrap(err, "error occurred when scheduling a record")
+	}
+
+	return nil
+}
+
+// startDispatching does not run a new goroutine and should be called in a new one.
+func (s *Scheduler) startDispatching(ctx context.Context) {
+	ticker := time.NewTicker(s.cfg.PollInterval)
+
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			for {
+				dispatched, err := s.dispatch(ctx)
+				if err != nil {
+					logger.Errorf("error occurred when dispatching scheduled records: %v", err)
+				}
+
+				if err != nil || dispatched < s.cfg.BatchSize {
+					break
+				}
+			}
+		}
+	}
+}
+
+// dispatch publishes a batch of due records and returns how many were published.
+func (s *Scheduler) dispatch(ctx context.Context) (int, error) {
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return 0, errors.Wrap(err, "error occurred when beginning a transaction")
+	}
+
+	defer func() {
+		_ = tx.Rollback(ctx)
+	}()
+
+	rows, err := tx.Query(
+		ctx,
+		`SELECT id, topic, value, deliver_at, request_id FROM scheduled_messages
+		WHERE deliver_at <= now() ORDER BY deliver_at LIMIT $1 FOR UPDATE SKIP LOCKED`,
+		s.cfg.BatchSize,
+	)
+	if err != nil {
+		return 0, errors.Wrap(err, "error occurred when selecting due records")
+	}
+
+	type scheduled struct {
+		id        int64
+		topic     string
+		value     []byte
+		deliverAt time.Time
+		requestID string
+	}
+
+	due, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (scheduled, error) {
+		var res scheduled
+
+		err := row.Scan(&res.id, &res.topic, &res.value, &res.deliverAt, &res.requestID)
+
+		return res, err
+	})
+	if err != nil {
+		return 0, errors.Wrap(err, "error occurred when scanning due records")
+	}
+
+	if len(due) == 0 {
+		return 0, nil
+	}
+
+	ids := make([]int64, 0, len(due))
+
+	for _, record := range due {
+		recordCtx := ctx
+		if record.requestID != "" {
+			recordCtx = requestid.NewContext(ctx, record.requestID)
+		}
+
+		if err = s.publisher.Produce(recordCtx, record.topic, record.value); err != nil {
+			break
+		}
+
+		s.metrics.deliveryLateness.WithLabelValues(record.topic).Observe(time.Since(record.deliverAt).Seconds())
+
+		ids = append(ids, record.id)
+	}
+
+	if len(ids) > 0 {
+		if _, delErr := tx.Exec(ctx, "DELETE FROM scheduled_messages WHERE id = ANY($1)", ids); delErr != nil {
+			return 0, errors.Wrap(delErr, "error occurred when deleting dispatched records")
+		}
+
+		if commitErr := tx.Commit(ctx); commitErr != nil {
+			return 0, errors.Wrap(commitErr, "error occurred when committing dispatched records")
+		}
+	}
+
+	if err != nil {
+		return len(ids), errors.Wrap(err, "error occurred when publishing a scheduled record")
+	}
+
+	return len(ids), nil
+}
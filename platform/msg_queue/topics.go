@@ -0,0 +1,312 @@
+package msg_queue
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+
+	"platform/logger"
+
+	"github.com/pkg/errors"
+	"github.com/twmb/franz-go/pkg/kadm"
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+// TopicSpec declares a topic a service needs. Services declare specs next to their HandlerTable
+// and reconcile them at startup with MustReconcileTopics or with the mqctl command.
+type TopicSpec struct {
+	Name              string `json:"name"`
+	Partitions        int32  `json:"partitions"`
+	ReplicationFactor int16  `json:"replication_factor"`
+	// RetentionMs is retention.ms, zero means the broker default.
+	RetentionMs int64 `json:"retention_ms"`
+	// CleanupPolicy is cleanup.policy ("delete", "compact" or "compact,delete"), empty means the broker default.
+	CleanupPolicy string `json:"cleanup_policy"`
+	// Configs are any other topic configs, e.g. "min.insync.replicas".
+	Configs map[string]string `json:"configs"`
+}
+
+func (spec TopicSpec) configs() map[string]string {
+	configs := maps.Clone(spec.Configs)
+	if configs == nil {
+		configs = make(map[string]string)
+	}
+
+	if spec.RetentionMs != 0 {
+		configs["retention.ms"] = strconv.FormatInt(spec.RetentionMs, 10)
+	}
+
+	if spec.CleanupPolicy != "" {
+		configs["cleanup.policy"] = spec.CleanupPolicy
+	}
+
+	return configs
+}
+
+type DriftKind string
+
+const (
+	DriftMissing     DriftKind = "missing"
+	DriftPartitions  DriftKind = "partitions"
+	DriftReplication DriftKind = "replication_factor"
+	DriftConfig      DriftKind = "config"
+)
+
+// TopicDrift is a difference between a TopicSpec and the cluster.
+// Missing topics and missing partitions are fixed by ReconcileTopics, other drifts are only reported.
+type TopicDrift struct {
+	Topic string
+	Kind  DriftKind
+	// Config is the name of the drifted config for DriftConfig.
+	Config string
+	Want   string
+	Have   string
+	Fixed  bool
+}
+
+func (d TopicDrift) String() string {
+	name := string(d.Kind)
+	if d.Config != "" {
+		name += " " + d.Config
+	}
+
+	status := "not fixed"
+	if d.Fixed {
+		status = "fixed"
+	}
+
+	return fmt.Sprintf("topic %s: %s: want %q, have %q (%s)", d.Topic, name, d.Want, d.Have, status)
+}
+
+// MustCreateKafkaAdmin creates an admin client for the cluster configured by the environment (see LoadKafkaOptions).
+func MustCreateKafkaAdmin() *kadm.Client {
+	opts, err := LoadKafkaOptions()
+	if err != nil {
+		logger.Fatal(err.Error())
+
+		return nil
+	}
+
+	admin, err := NewKafkaAdmin(opts)
+	if err != nil {
+		logger.Fatal(err.Error())
+
+		return nil
+	}
+
+	return admin
+}
+
+// NewKafkaAdmin creates an admin client for the cluster, only the connection options are used.
+func NewKafkaAdmin(opts KafkaOptions) (*kadm.Client, error) {
+	kgoOpts, err := opts.connOpts()
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := kgo.NewClient(kgoOpts...)
+	if err != nil {
+		return nil, errors.Wrap(err, "error occurred when creating a kafka client")
+	}
+
+	return kadm.NewClient(client), nil
+}
+
+// MustReconcileTopics reconciles the specs and logs the drift.
+func MustReconcileTopics(ctx context.Context, specs ...TopicSpec) {
+	admin := MustCreateKafkaAdmin()
+	defer admin.Close()
+
+	drifts, err := ReconcileTopics(ctx, admin, false, specs...)
+	if err != nil {
+		logger.Fatal(err.Error())
+	}
+
+	for _, drift := range drifts {
+		logger.Infof("kafka topic drift: %s", drift)
+	}
+}
+
+// ReconcileTopics creates missing topics and widens partitions to match the specs,
+// and returns every difference found. With dryRun nothing is changed.
+func ReconcileTopics(
+	ctx context.Context,
+	admin *kadm.Client,
+	dryRun bool,
+	specs ...TopicSpec,
+) ([]TopicDrift, error) {
+	names := make([]string, 0, len(specs))
+	for _, spec := range specs {
+		names = append(names, spec.Name)
+	}
+
+	details, err := admin.ListTopics(ctx, names...)
+	if err != nil {
+		return nil, errors.Wrap(err, "error occurred when listing kafka topics")
+	}
+
+	var (
+		drifts   []TopicDrift
+		existing []string
+	)
+
+	for _, spec := range specs {
+		if !details.Has(spec.Name) {
+			drift := TopicDrift{Topic: spec.Name, Kind: DriftMissing, Want: "exists", Have: "missing"}
+
+			if !dryRun {
+				if err = createTopic(ctx, admin, spec); err != nil {
+					return drifts, err
+				}
+
+				drift.Fixed = true
+			}
+
+			drifts = append(drifts, drift)
+
+			continue
+		}
+
+		existing = append(existing, spec.Name)
+
+		detail := details[spec.Name]
+		if detail.Err != nil {
+			return drifts, errors.Wrapf(detail.Err, "error occurred when describing kafka topic %s", spec.Name)
+		}
+
+		partitionDrifts, err := reconcilePartitions(ctx, admin, dryRun, spec, detail)
+		if err != nil {
+			return drifts, err
+		}
+
+		drifts = append(drifts, partitionDrifts...)
+	}
+
+	if len(existing) == 0 {
+		return drifts, nil
+	}
+
+	configs, err := admin.DescribeTopicConfigs(ctx, existing...)
+	if err != nil {
+		return drifts, errors.Wrap(err, "error occurred when describing kafka topic configs")
+	}
+
+	for _, spec := range specs {
+		if !slices.Contains(existing, spec.Name) {
+			continue
+		}
+
+		resource, err := configs.On(spec.Name, nil)
+		if err != nil {
+			return drifts, errors.Wrapf(err, "error occurred when describing kafka topic %s configs", spec.Name)
+		}
+
+		drifts = append(drifts, configDrifts(spec, resource)...)
+	}
+
+	return drifts, nil
+}
+
+func createTopic(ctx context.Context, admin *kadm.Client, spec TopicSpec) error {
+	configs := make(map[string]*string)
+	for key, value := range spec.configs() {
+		configs[key] = kadm.StringPtr(value)
+	}
+
+	partitions, replicationFactor := spec.Partitions, spec.ReplicationFactor
+	if partitions == 0 {
+		partitions = -1
+	}
+
+	if replicationFactor == 0 {
+		replicationFactor = -1
+	}
+
+	responses, err := admin.CreateTopics(ctx, partitions, replicationFactor, configs, spec.Name)
+	if err == nil {
+		err = responses[spec.Name].Err
+	}
+
+	if err != nil {
+		return errors.Wrapf(err, "error occurred when creating kafka topic %s", spec.Name)
+	}
+
+	logger.Infof("kafka topic %s is created", spec.Name)
+
+	return nil
+}
+
+func reconcilePartitions(
+	ctx context.Context,
+	admin *kadm.Client,
+	dryRun bool,
+	spec TopicSpec,
+	detail kadm.TopicDetail,
+) ([]TopicDrift, error) {
+	var drifts []TopicDrift
+
+	have := int32(len(detail.Partitions))
+	if spec.Partitions != 0 && have != spec.Partitions {
+		drift := TopicDrift{
+			Topic: spec.Name,
+			Kind:  DriftPartitions,
+			Want:  strconv.Itoa(int(spec.Partitions)),
+			Have:  strconv.Itoa(int(have)),
+		}
+
+		// Kafka can not decrease the number of partitions, so only widening is fixed.
+		if have < spec.Partitions && !dryRun {
+			responses, err := admin.UpdatePartitions(ctx, int(spec.Partitions), spec.Name)
+			if err == nil {
+				err = responses[spec.Name].Err
+			}
+
+			if err != nil {
+				return drifts, errors.Wrapf(err, "error occurred when widening kafka topic %s", spec.Name)
+			}
+
+			drift.Fixed = true
+		}
+
+		drifts = append(drifts, drift)
+	}
+
+	replicas := int16(detail.Partitions.NumReplicas())
+	if spec.ReplicationFactor != 0 && replicas != spec.ReplicationFactor {
+		drifts = append(drifts, TopicDrift{
+			Topic: spec.Name,
+			Kind:  DriftReplication,
+			Want:  strconv.Itoa(int(spec.ReplicationFactor)),
+			Have:  strconv.Itoa(int(replicas)),
+		})
+	}
+
+	return drifts, nil
+}
+
+func configDrifts(spec TopicSpec, resource kadm.ResourceConfig) []TopicDrift {
+	have := make(map[string]string, len(resource.Configs))
+	for _, config := range resource.Configs {
+		have[config.Key] = config.MaybeValue()
+	}
+
+	var drifts []TopicDrift
+
+	want := spec.configs()
+
+	for _, key := range slices.Sorted(maps.Keys(want)) {
+		if have[key] != want[key] {
+			drifts = append(drifts, TopicDrift{
+				Topic:  spec.Name,
+				Kind:   DriftConfig,
+				Config: key,
+				Want:   want[key],
+				Have:   have[key],
+			})
+		}
+	}
+
+	return drifts
+}
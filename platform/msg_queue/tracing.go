@@ -0,0 +1,28 @@
+package msg_queue
+
+import (
+	"context"
+
+	"github.com/twmb/franz-go/pkg/kgo"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// startProducerSpan starts a span for producing the record and puts its context into the record headers,
+// so the span of Tracing on the consumer side is its child.
+func startProducerSpan(ctx context.Context, record *kgo.Record) (context.Context, trace.Span) {
+	ctx, span := otel.Tracer("platform/msg_queue").Start(
+		ctx,
+		record.Topic+" publish",
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(
+			attribute.String("messaging.system", "kafka"),
+			attribute.String("messaging.destination.name", record.Topic),
+		),
+	)
+
+	otel.GetTextMapPropagator().Inject(ctx, recordCarrier{record: record})
+
+	return ctx, span
+}
@@ -0,0 +1,320 @@
+package msg_queue
+
+import (
+	"context"
+	"maps"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"platform/logger"
+
+	"github.com/caarlos0/env/v11"
+	"github.com/pkg/errors"
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+const transactAbortBackoff = time.Second
+
+// ErrNotInTransaction is returned by Emit when the handler is not run by a TransactProcessor.
+var ErrNotInTransaction = errors.New("record is not handled in a transaction")
+
+type transactionalConfig struct {
+	TransactionalID string `env:"KAFKA_TRANSACTIONAL_ID, required, notEmpty"`
+}
+
+type transactOutput struct {
+	mu      sync.Mutex
+	records []*kgo.Record
+}
+
+type transactOutputKey struct{}
+
+// Emit adds a record to the transaction of the record being handled. The record is produced
+// only if the transaction commits, atomically with the offset of the handled record.
+func Emit(ctx context.Context, record *kgo.Record) error {
+	output, ok := ctx.Value(transactOutputKey{}).(*transactOutput)
+	if !ok {
+		return ErrNotInTransaction
+	}
+
+	withRequestID(ctx, record)
+
+	// The record is produced on commit, the span only links the consumer to the handler.
+	_, span := startProducerSpan(ctx, record)
+	span.End()
+
+	output.mu.Lock()
+	output.records = append(output.records, record)
+	output.mu.Unlock()
+
+	return nil
+}
+
+// TransactProcessor consumes topics and produces the records emitted by handlers (see Emit)
+// in kafka transactions, so the output records and the input offsets are committed atomically
+// (consume-transform-produce with exactly-once semantics).
+//
+// Records of every poll are handled in one transaction, in order within a partition.
+// If a handler returns an error, the record goes to the dead letter topic of the Handler
+// in the same transaction; if there is none, the transaction is aborted and the records are retried.
+// AckBeforeProcessing has no effect in this mode.
+type TransactProcessor struct {
+	session      transactSession
+	table        HandlerTable
+	metrics      *metricHooks
+	isSubscribed atomic.Bool
+}
+
+var _ Subscriber = (*TransactProcessor)(nil)
+
+// transactSession is the part of kgo.GroupTransactSession used by TransactProcessor.
+type transactSession interface {
+	Client() *kgo.Client
+	PollFetches(ctx context.Context) kgo.Fetches
+	Begin() error
+	ProduceSync(ctx context.Context, rs ...*kgo.Record) kgo.ProduceResults
+	End(ctx context.Context, commit kgo.TransactionEndTry) (bool, error)
+	SetOffsets(offsets map[string]map[int32]kgo.EpochOffset)
+	Close()
+}
+
+// groupTransactSession sets the offsets of the session through its client.
+type groupTransactSession struct {
+	*kgo.GroupTransactSession
+}
+
+func (s groupTransactSession) SetOffsets(offsets map[string]map[int32]kgo.EpochOffset) {
+	s.Client().SetOffsets(offsets)
+}
+
+func MustCreateTransactProcessor(table HandlerTable) *TransactProcessor {
+	opts, err := LoadKafkaOptions()
+	if err != nil {
+		logger.Fatal(err.Error())
+
+		return nil
+	}
+
+	txnCfg, err := env.ParseAs[transactionalConfig]()
+	if err != nil {
+		logger.Fatal(err.Error())
+
+		return nil
+	}
+
+	processor, err := NewTransactProcessor(opts, txnCfg.TransactionalID, table)
+	if err != nil {
+		logger.Fatal(err.Error())
+
+		return nil
+	}
+
+	return processor
+}
+
+// NewTransactProcessor creates a processor and subscribes it to opts.Topics.
+func NewTransactProcessor(
+	opts KafkaOptions,
+	transactionalID string,
+	table HandlerTable,
+) (*TransactProcessor, error) {
+	subscribed, err := opts.subscribedTable(table)
+	if err != nil {
+		return nil, err
+	}
+
+	metrics := newMetricHooks(opts.Group)
+
+	kgoOpts, err := opts.clientOpts(metrics, rebalanceCallbacks{})
+	if err != nil {
+		return nil, err
+	}
+
+	session, err := kgo.NewGroupTransactSession(append(
+		kgoOpts,
+		kgo.DisableAutoCommit(),
+		kgo.TransactionalID(transactionalID),
+		kgo.FetchIsolationLevel(kgo.ReadCommitted()),
+		kgo.RequireStableFetchOffsets(),
+	)...)
+	if err != nil {
+		return nil, errors.Wrap(err, "error occurred when creating a kafka transact session")
+	}
+
+	processor := &TransactProcessor{
+		session: groupTransactSession{session},
+		metrics: metrics,
+	}
+
+	if len(subscribed) > 0 {
+		if err = processor.Subscribe(subscribed); err != nil {
+			session.Close()
+
+			return nil, err
+		}
+	}
+
+	return processor, nil
+}
+
+// Subscribe starts consuming the topics of the table in a new goroutine.
+func (p *TransactProcessor) Subscribe(table HandlerTable) error {
+	if !p.isSubscribed.CompareAndSwap(false, true) {
+		return ErrAlreadySubscribed
+	}
+
+	p.table = UseMiddleware(table, p.metrics.handlerMetrics)
+
+	p.session.Client().AddConsumeTopics(slices.Collect(maps.Keys(table))...)
+
+	go p.startPolling()
+
+	return nil
+}
+
+// startPolling does not run a new goroutine and should be called in a new one.
+func (p *TransactProcessor) startPolling() {
+	ctx := p.session.Client().Context()
+
+	go p.metrics.watchLag(ctx, p.session.Client())
+
+	for ctx.Err() == nil {
+		p.transact(ctx, p.session.PollFetches(ctx))
+	}
+}
+
+// transact handles the records of the fetches in one transaction. The fetches are counted as polled
+// by the session, so every record must be handled or the transaction aborted, which rewinds the offsets:
+// a partition error is only logged, the records of the other partitions are handled.
+func (p *TransactProcessor) transact(ctx context.Context, fetches kgo.Fetches) {
+	for _, e := range fetches.Errors() {
+		logger.Errorf("error occurred when polling topic %s, partition %d: %v", e.Topic, e.Partition, e.Err)
+	}
+
+	if fetches.Empty() {
+		return
+	}
+
+	if err := p.session.Begin(); err != nil {
+		logger.Errorf("error occurred when beginning a kafka transaction: %v", err)
+
+		// The records are not handled, they are fetched again from their first offsets.
+		p.session.SetOffsets(firstOffsets(fetches))
+		p.backoff(ctx)
+
+		return
+	}
+
+	commit := kgo.TryCommit
+
+	records, ok := p.handle(ctx, fetches)
+	if !ok {
+		commit = kgo.TryAbort
+	} else if len(records) > 0 {
+		if err := p.session.ProduceSync(ctx, records...).FirstErr(); err != nil {
+			logger.Errorf("error occurred when producing records in a kafka transaction: %v", err)
+
+			commit = kgo.TryAbort
+		}
+	}
+
+	committed, err := p.session.End(ctx, commit)
+	if err != nil {
+		logger.Errorf("error occurred when ending a kafka transaction: %v", err)
+	}
+
+	p.metrics.observeTransaction(committed)
+
+	if !committed {
+		logger.Info("kafka transaction is aborted, the records will be handled again")
+
+		p.backoff(ctx)
+	}
+}
+
+func (p *TransactProcessor) backoff(ctx context.Context) {
+	select {
+	case <-ctx.Done():
+	case <-time.After(transactAbortBackoff):
+	}
+}
+
+// firstOffsets returns the offset of the first record of every fetched partition.
+func firstOffsets(fetches kgo.Fetches) map[string]map[int32]kgo.EpochOffset {
+	offsets := make(map[string]map[int32]kgo.EpochOffset)
+
+	fetches.EachPartition(func(partition kgo.FetchTopicPartition) {
+		if len(partition.Records) == 0 {
+			return
+		}
+
+		if offsets[partition.Topic] == nil {
+			offsets[partition.Topic] = make(map[int32]kgo.EpochOffset)
+		}
+
+		first := partition.Records[0]
+		offsets[partition.Topic][partition.Partition] = kgo.EpochOffset{Epoch: first.LeaderEpoch, Offset: first.Offset}
+	})
+
+	return offsets
+}
+
+// handle runs the handlers of every partition concurrently and returns the records to produce
+// and whether the transaction can be committed.
+func (p *TransactProcessor) handle(ctx context.Context, fetches kgo.Fetches) ([]*kgo.Record, bool) {
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		records  []*kgo.Record
+		isFailed atomic.Bool
+	)
+
+	fetches.EachPartition(func(partition kgo.FetchTopicPartition) {
+		handler := p.table[partition.Topic]
+
+		wg.Go(func() {
+			for _, record := range partition.Records {
+				if isFailed.Load() {
+					return
+				}
+
+				output := &transactOutput{}
+				recordCtx := context.WithValue(contextWithRequestID(ctx, record), transactOutputKey{}, output)
+
+				err := handler.fn(recordCtx, record)
+				if err == nil {
+					mu.Lock()
+					records = append(records, output.records...)
+					mu.Unlock()
+
+					continue
+				}
+
+				logger.WithContext(recordCtx).Errorf(
+					"error occurred when handling a record from topic %s, partition %d, offset %d: %v",
+					record.Topic, record.Partition, record.Offset, err,
+				)
+
+				if handler.deadLetterTopic == "" {
+					isFailed.Store(true)
+
+					return
+				}
+
+				mu.Lock()
+				records = append(records, deadLetterRecord(handler.deadLetterTopic, record, err))
+				mu.Unlock()
+			}
+		})
+	})
+
+	wg.Wait()
+
+	return records, !isFailed.Load()
+}
+
+func (p *TransactProcessor) Close() {
+	p.session.Close()
+}
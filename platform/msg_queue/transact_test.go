@@ -0,0 +1,164 @@
+package msg_queue
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+type fakeTransactSession struct {
+	mu       sync.Mutex
+	beginErr error
+	begun    int
+	produced []*kgo.Record
+	ends     []kgo.TransactionEndTry
+	offsets  map[string]map[int32]kgo.EpochOffset
+}
+
+func (s *fakeTransactSession) Client() *kgo.Client { return nil }
+
+func (s *fakeTransactSession) PollFetches(context.Context) kgo.Fetches { return nil }
+
+func (s *fakeTransactSession) Begin() error {
+	s.begun++
+
+	return s.beginErr
+}
+
+func (s *fakeTransactSession) ProduceSync(_ context.Context, rs ...*kgo.Record) kgo.ProduceResults {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.produced = append(s.produced, rs...)
+
+	return nil
+}
+
+func (s *fakeTransactSession) End(_ context.Context, commit kgo.TransactionEndTry) (bool, error) {
+	s.ends = append(s.ends, commit)
+
+	return bool(commit), nil
+}
+
+func (s *fakeTransactSession) SetOffsets(offsets map[string]map[int32]kgo.EpochOffset) {
+	s.offsets = offsets
+}
+
+func (s *fakeTransactSession) Close() {}
+
+func TestTransactProcessorHandlesRecordsOfFetchWithPartitionError(t *testing.T) {
+	session := &fakeTransactSession{}
+
+	var (
+		mu      sync.Mutex
+		handled []int64
+	)
+
+	processor := &TransactProcessor{
+		session: session,
+		metrics: newMetricHooks(testGroup),
+		table: HandlerTable{
+			"orders": NewHandler(func(ctx context.Context, record *kgo.Record) error {
+				mu.Lock()
+				handled = append(handled, record.Offset)
+				mu.Unlock()
+
+				return Emit(ctx, &kgo.Record{Topic: "invoices", Value: record.Value})
+			}),
+		},
+	}
+
+	fetches := kgo.Fetches{{
+		Topics: []kgo.FetchTopic{{
+			Topic: "orders",
+			Partitions: []kgo.FetchPartition{
+				{Partition: 0, Err: errors.New("not leader for partition")},
+				{
+					Partition:     1,
+					HighWatermark: 2,
+					Records: []*kgo.Record{
+						{Topic: "orders", Partition: 1, Offset: 0, Value: []byte("a")},
+						{Topic: "orders", Partition: 1, Offset: 1, Value: []byte("b")},
+					},
+				},
+			},
+		}},
+	}}
+
+	processor.transact(context.Background(), fetches)
+
+	assert.Equal(t, []int64{0, 1}, handled)
+	assert.Equal(t, 1, session.begun)
+	assert.Len(t, session.produced, 2)
+	assert.Equal(t, []kgo.TransactionEndTry{kgo.TryCommit}, session.ends)
+}
+
+func TestTransactProcessorSkipsFetchWithOnlyErrors(t *testing.T) {
+	session := &fakeTransactSession{}
+
+	processor := &TransactProcessor{
+		session: session,
+		metrics: newMetricHooks(testGroup),
+		table:   HandlerTable{},
+	}
+
+	processor.transact(context.Background(), kgo.Fetches{{
+		Topics: []kgo.FetchTopic{{
+			Topic:      "orders",
+			Partitions: []kgo.FetchPartition{{Partition: 0, Err: errors.New("not leader for partition")}},
+		}},
+	}})
+
+	assert.Zero(t, session.begun)
+	assert.Empty(t, session.ends)
+}
+
+func TestTransactProcessorRewindsFetchWhenBeginFails(t *testing.T) {
+	session := &fakeTransactSession{beginErr: errors.New("coordinator not available")}
+
+	var handled int
+
+	processor := &TransactProcessor{
+		session: session,
+		metrics: newMetricHooks(testGroup),
+		table: HandlerTable{
+			"orders": NewHandler(func(context.Context, *kgo.Record) error {
+				handled++
+
+				return nil
+			}),
+		},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	processor.transact(ctx, kgo.Fetches{{
+		Topics: []kgo.FetchTopic{{
+			Topic: "orders",
+			Partitions: []kgo.FetchPartition{
+				{
+					Partition: 0,
+					Records: []*kgo.Record{
+						{Topic: "orders", Partition: 0, Offset: 7, LeaderEpoch: 2},
+						{Topic: "orders", Partition: 0, Offset: 8, LeaderEpoch: 2},
+					},
+				},
+				{
+					Partition: 1,
+					Records:   []*kgo.Record{{Topic: "orders", Partition: 1, Offset: 3, LeaderEpoch: 1}},
+				},
+			},
+		}},
+	}})
+
+	assert.Zero(t, handled)
+	assert.Empty(t, session.ends)
+	assert.Equal(t, map[string]map[int32]kgo.EpochOffset{
+		"orders": {0: {Epoch: 2, Offset: 7}, 1: {Epoch: 1, Offset: 3}},
+	}, session.offsets)
+}
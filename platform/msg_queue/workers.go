@@ -0,0 +1,97 @@
+package msg_queue
+
+import (
+	"sync"
+
+	"platform/logger"
+
+	"github.com/panjf2000/ants/v2"
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+// workers bounds the records being handled by the size of the pool, so handing a record over never blocks
+// the poll loop. A record that finds no free worker waits in the backlog, which the workers drain before
+// they return, and fetching is paused for all the topics until the backlog is empty: the backlog holds
+// at most the rest of one poll and the redelivered records.
+type workers struct {
+	client *kgo.Client
+	pool   *ants.PoolWithFuncGeneric[workerArgs]
+	size   int
+	topics []string
+
+	mu       sync.Mutex
+	running  int
+	backlog  []workerArgs
+	isPaused bool
+}
+
+func newWorkers(client *kgo.Client, pool *ants.PoolWithFuncGeneric[workerArgs], topics []string) *workers {
+	return &workers{
+		client: client,
+		pool:   pool,
+		size:   pool.Cap(),
+		topics: topics,
+	}
+}
+
+// dispatch hands the record to a free worker, or puts it in the backlog when there is none.
+func (w *workers) dispatch(args workerArgs) {
+	w.mu.Lock()
+
+	if w.running >= w.size {
+		w.backlog = append(w.backlog, args)
+
+		if !w.isPaused {
+			w.client.PauseFetchTopics(w.topics...)
+			w.isPaused = true
+		}
+
+		w.mu.Unlock()
+
+		return
+	}
+
+	w.running++
+	w.mu.Unlock()
+
+	// A worker is free or about to be, Invoke returns at once.
+	if err := w.pool.Invoke(args); err != nil {
+		logger.Errorf("error occurred when invoking a kafka worker: %v", err)
+
+		w.mu.Lock()
+		w.running--
+		w.mu.Unlock()
+	}
+}
+
+// next is called by a worker when its record is handled. It returns the next record of the backlog,
+// or false when the backlog is empty and the worker is free, fetching is resumed then.
+func (w *workers) next() (workerArgs, bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if len(w.backlog) > 0 {
+		args := w.backlog[0]
+		w.backlog[0] = workerArgs{}
+		w.backlog = w.backlog[1:]
+
+		return args, true
+	}
+
+	w.running--
+
+	if w.isPaused {
+		w.client.ResumeFetchTopics(w.topics...)
+		w.isPaused = false
+	}
+
+	return workerArgs{}, false
+}
+
+// waiting returns the number of records in the backlog.
+func (w *workers) waiting() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return len(w.backlog)
+}
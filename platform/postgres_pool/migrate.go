@@ -0,0 +1,89 @@
+package postgres_pool
+
+import (
+	"context"
+	"embed"
+	"io/fs"
+	"path"
+	"slices"
+	"strings"
+
+	"platform/logger"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/pkg/errors"
+)
+
+// migrationsLockID is the key of the advisory lock serializing the replicas that migrate the same database.
+const migrationsLockID = 7362108543
+
+// migrations holds the schema of the platform tables, one file per version applied in name order,
+// e.g. 0003_orders.sql. An applied file must not be changed, a change of schema is a new file.
+//
+//go:embed migrations/*.sql
+var migrations embed.FS
+
+// MustMigrate applies the migrations that are not in the schema_migrations table yet,
+// before the pool is used by the scheduler or the audit sink.
+func MustMigrate(ctx context.Context, pool *pgxpool.Pool) {
+	if err := migrate(ctx, pool); err != nil {
+		logger.Fatal(err.Error())
+	}
+}
+
+func migrate(ctx context.Context, pool *pgxpool.Pool) error {
+	names, err := fs.Glob(migrations, "migrations/*.sql")
+	if err != nil {
+		return errors.Wrap(err, "error occurred when listing the migrations")
+	}
+
+	slices.Sort(names)
+
+	return pgx.BeginFunc(ctx, pool, func(tx pgx.Tx) error {
+		if _, err := tx.Exec(ctx, "SELECT pg_advisory_xact_lock($1)", migrationsLockID); err != nil {
+			return errors.Wrap(err, "error occurred when locking the migrations")
+		}
+
+		if _, err := tx.Exec(
+			ctx,
+			"CREATE TABLE IF NOT EXISTS schema_migrations (version TEXT PRIMARY KEY, applied_at TIMESTAMPTZ NOT NULL DEFAULT now())",
+		); err != nil {
+			return errors.Wrap(err, "error occurred when creating the schema_migrations table")
+		}
+
+		rows, err := tx.Query(ctx, "SELECT version FROM schema_migrations")
+		if err != nil {
+			return errors.Wrap(err, "error occurred when reading the applied migrations")
+		}
+
+		applied, err := pgx.CollectRows(rows, pgx.RowTo[string])
+		if err != nil {
+			return errors.Wrap(err, "error occurred when reading the applied migrations")
+		}
+
+		for _, name := range names {
+			version := strings.TrimSuffix(path.Base(name), ".sql")
+			if slices.Contains(applied, version) {
+				continue
+			}
+
+			schema, err := migrations.ReadFile(name)
+			if err != nil {
+				return errors.Wrapf(err, "error occurred when reading the migration %s", version)
+			}
+
+			if _, err = tx.Exec(ctx, string(schema)); err != nil {
+				return errors.Wrapf(err, "error occurred when applying the migration %s", version)
+			}
+
+			if _, err = tx.Exec(ctx, "INSERT INTO schema_migrations (version) VALUES ($1)", version); err != nil {
+				return errors.Wrapf(err, "error occurred when recording the migration %s", version)
+			}
+
+			logger.Infof("applied the migration %s", version)
+		}
+
+		return nil
+	})
+}
@@ -0,0 +1,9 @@
+CREATE TABLE scheduled_messages (
+	id         BIGSERIAL PRIMARY KEY,
+	topic      TEXT        NOT NULL,
+	value      BYTEA       NOT NULL,
+	deliver_at TIMESTAMPTZ NOT NULL,
+	request_id TEXT        NOT NULL DEFAULT ''
+);
+
+CREATE INDEX scheduled_messages_deliver_at_idx ON scheduled_messages (deliver_at);
@@ -0,0 +1,13 @@
+CREATE TABLE audit_log (
+	id         BIGSERIAL PRIMARY KEY,
+	time       TIMESTAMPTZ NOT NULL,
+	actor      TEXT        NOT NULL,
+	operation  TEXT        NOT NULL,
+	request_id TEXT        NOT NULL,
+	payload    JSONB,
+	code       INTEGER     NOT NULL,
+	reason     TEXT        NOT NULL
+);
+
+CREATE INDEX audit_log_actor_time_idx ON audit_log (actor, time);
+CREATE INDEX audit_log_operation_time_idx ON audit_log (operation, time);
@@ -7,6 +7,7 @@ import (
 	"platform/logger"
 
 	"github.com/caarlos0/env/v11"
+	"github.com/jackc/pgx/v5/multitracer"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
@@ -44,7 +45,11 @@ func MustCreatePostgresPool() *pgxpool.Pool {
 		return nil
 	}
 
-	config.ConnConfig.Tracer = newPostgresLogger(logger.MainLogger())
+	config.ConnConfig.Tracer = multitracer.New(queryTracer{}, newPostgresLogger(logger.MainLogger()))
+
+	sessions := newTenantSessions()
+	config.PrepareConn = sessions.prepare
+	config.BeforeClose = sessions.forget
 
 	pool, err := pgxpool.NewWithConfig(context.Background(), config)
 	if err != nil {
@@ -0,0 +1,58 @@
+package postgres_pool
+
+import (
+	"context"
+	"sync"
+
+	"platform/tenant"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/pkg/errors"
+)
+
+// tenantSessions sets the app.tenant_id session variable of a connection to the tenant of the context
+// that acquires it, so row-level security policies can use it, e.g.
+//
+//	ALTER TABLE products ENABLE ROW LEVEL SECURITY;
+//	CREATE POLICY tenant_isolation ON products USING (tenant_id = current_setting('app.tenant_id'));
+//
+// The variable is empty for the contexts without a tenant. It is set only when the tenant of a connection changes.
+type tenantSessions struct {
+	mu      sync.Mutex
+	tenants map[*pgx.Conn]string
+}
+
+func newTenantSessions() *tenantSessions {
+	return &tenantSessions{tenants: make(map[*pgx.Conn]string)}
+}
+
+// prepare is pgxpool.Config.PrepareConn.
+func (s *tenantSessions) prepare(ctx context.Context, conn *pgx.Conn) (bool, error) {
+	id := tenant.FromContext(ctx)
+
+	s.mu.Lock()
+	current, isSet := s.tenants[conn]
+	s.mu.Unlock()
+
+	if isSet && current == id {
+		return true, nil
+	}
+
+	if _, err := conn.Exec(ctx, "SELECT set_config('app.tenant_id', $1, false)", id); err != nil {
+		// The connection is destroyed, so it cannot be used with the tenant of another request.
+		return false, errors.Wrap(err, "error occurred when setting the tenant of a connection")
+	}
+
+	s.mu.Lock()
+	s.tenants[conn] = id
+	s.mu.Unlock()
+
+	return true, nil
+}
+
+// forget is pgxpool.Config.BeforeClose.
+func (s *tenantSessions) forget(conn *pgx.Conn) {
+	s.mu.Lock()
+	delete(s.tenants, conn)
+	s.mu.Unlock()
+}
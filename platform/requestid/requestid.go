@@ -0,0 +1,61 @@
+// Package requestid carries the ID of a request through the context, the transports and the logs,
+// so the lines of one request can be found by the ID returned to the client.
+package requestid
+
+import (
+	"context"
+
+	"github.com/go-kratos/kratos/v2/log"
+	"github.com/google/uuid"
+)
+
+// Header is the HTTP header, the gRPC metadata and the kafka record header of the request ID.
+const Header = "X-Request-ID"
+
+// MaxLength bounds the IDs accepted from clients.
+const MaxLength = 128
+
+type requestIDKey struct{}
+
+func NewContext(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// FromContext returns the request ID of ctx or an empty string.
+func FromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+
+	return id
+}
+
+// New generates a request ID.
+func New() string {
+	return uuid.NewString()
+}
+
+// IsValid reports whether an ID received from a client can be used: it is not empty,
+// not longer than MaxLength and consists of printable ASCII characters only, so it is safe to log.
+func IsValid(id string) bool {
+	if id == "" || len(id) > MaxLength {
+		return false
+	}
+
+	for i := range len(id) {
+		if id[i] < '!' || id[i] > '~' {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Valuer is a log.Valuer of the request ID of the context of a log line.
+func Valuer() log.Valuer {
+	return func(ctx context.Context) any {
+		if ctx == nil {
+			return ""
+		}
+
+		return FromContext(ctx)
+	}
+}
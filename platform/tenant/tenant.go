@@ -0,0 +1,56 @@
+// Package tenant carries the store a request belongs to through the context, so the caches,
+// the rate limits, the metrics and the Postgres sessions of the request are scoped to it.
+package tenant
+
+import (
+	"context"
+
+	"github.com/go-kratos/kratos/v2/log"
+)
+
+// Header is the HTTP header and the gRPC metadata of the tenant ID.
+const Header = "X-Tenant-ID"
+
+// MaxLength bounds the tenant IDs.
+const MaxLength = 64
+
+type tenantKey struct{}
+
+func NewContext(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, tenantKey{}, id)
+}
+
+// FromContext returns the tenant ID of ctx or an empty string.
+func FromContext(ctx context.Context) string {
+	id, _ := ctx.Value(tenantKey{}).(string)
+
+	return id
+}
+
+// IsValid reports whether an ID can be used as a tenant: it is not empty, not longer than MaxLength
+// and consists of lowercase letters, digits, '-' and '_' only, so it is safe in keys and labels.
+func IsValid(id string) bool {
+	if id == "" || len(id) > MaxLength {
+		return false
+	}
+
+	for i := range len(id) {
+		c := id[i]
+		if (c < 'a' || c > 'z') && (c < '0' || c > '9') && c != '-' && c != '_' {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Valuer is a log.Valuer of the tenant ID of the context of a log line.
+func Valuer() log.Valuer {
+	return func(ctx context.Context) any {
+		if ctx == nil {
+			return ""
+		}
+
+		return FromContext(ctx)
+	}
+}
@@ -0,0 +1,150 @@
+package tracing
+
+import (
+	"context"
+	"io"
+	"os"
+	"time"
+
+	"platform/logger"
+
+	"github.com/caarlos0/env/v11"
+	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.37.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const shutdownTimeout = 5 * time.Second
+
+type config struct {
+	// Exporter is "none", "otlp", "stdout" or "file".
+	Exporter     string  `env:"TRACING_EXPORTER" envDefault:"none"`
+	OTLPEndpoint string  `env:"TRACING_OTLP_ENDPOINT" envDefault:"localhost:4317"`
+	OTLPInsecure bool    `env:"TRACING_OTLP_INSECURE" envDefault:"true"`
+	File         string  `env:"TRACING_FILE" envDefault:"traces.json"`
+	SampleRatio  float64 `env:"TRACING_SAMPLE_RATIO" envDefault:"1"`
+}
+
+// MustInitTracerProvider sets the global TracerProvider of the service, used by the kratos tracing
+// middleware, the postgres pool and the kafka clients, and the W3C trace context propagator.
+// The returned function flushes the spans, call it on shutdown.
+func MustInitTracerProvider(ctx context.Context, name string, version string) func() {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{},
+		propagation.Baggage{},
+	))
+
+	cfg, err := env.ParseAs[config]()
+	if err != nil {
+		logger.Fatal(err.Error())
+
+		return nil
+	}
+
+	if cfg.SampleRatio < 0 || cfg.SampleRatio > 1 {
+		logger.Fatalf("error occurred when initializing tracing: sample ratio %v is not in [0, 1]", cfg.SampleRatio)
+
+		return nil
+	}
+
+	exporter, closeExporter, err := newExporter(ctx, cfg)
+	if err != nil {
+		logger.Fatal(err.Error())
+
+		return nil
+	}
+
+	if exporter == nil {
+		return func() {}
+	}
+
+	res, err := resource.Merge(resource.Default(), resource.NewWithAttributes(
+		semconv.SchemaURL,
+		semconv.ServiceName(name),
+		semconv.ServiceVersion(version),
+	))
+	if err != nil {
+		logger.Fatal(errors.Wrap(err, "error occurred when creating a tracing resource").Error())
+
+		return nil
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(cfg.SampleRatio))),
+	)
+
+	otel.SetTracerProvider(provider)
+
+	return func() {
+		shutdownCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), shutdownTimeout)
+		defer cancel()
+
+		if err := provider.Shutdown(shutdownCtx); err != nil {
+			logger.Errorf("error occurred when shutting down the tracer provider: %v", err)
+		}
+
+		if err := closeExporter(); err != nil {
+			logger.Errorf("error occurred when closing the tracing exporter: %v", err)
+		}
+	}
+}
+
+func newExporter(ctx context.Context, cfg config) (sdktrace.SpanExporter, func() error, error) {
+	noClose := func() error { return nil }
+
+	switch cfg.Exporter {
+	case "none", "":
+		return nil, noClose, nil
+	case "otlp":
+		opts := []otlptracegrpc.Option{otlptracegrpc.WithEndpoint(cfg.OTLPEndpoint)}
+		if cfg.OTLPInsecure {
+			opts = append(opts, otlptracegrpc.WithInsecure())
+		}
+
+		exporter, err := otlptracegrpc.New(ctx, opts...)
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "error occurred when creating an otlp exporter")
+		}
+
+		return exporter, noClose, nil
+	case "stdout":
+		return newWriterExporter(os.Stdout, noClose)
+	case "file":
+		file, err := os.OpenFile(cfg.File, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "error occurred when opening the tracing file")
+		}
+
+		return newWriterExporter(file, file.Close)
+	default:
+		return nil, nil, errors.Errorf("error occurred when initializing tracing: unknown exporter %q", cfg.Exporter)
+	}
+}
+
+func newWriterExporter(w io.Writer, closeWriter func() error) (sdktrace.SpanExporter, func() error, error) {
+	exporter, err := stdouttrace.New(stdouttrace.WithWriter(w))
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "error occurred when creating a stdout exporter")
+	}
+
+	return exporter, closeWriter, nil
+}
+
+// EndSpan records err, if any, and ends the span.
+func EndSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+
+	span.End()
+}
@@ -3,13 +3,21 @@ package main
 import (
 	"context"
 	"os"
+	"platform/admin"
+	"platform/audit"
 	"platform/logger"
+	"platform/metric"
+	"platform/tracing"
+	"time"
 
 	"github.com/go-kratos/kratos/v2"
 	"github.com/go-kratos/kratos/v2/transport/http"
 	_ "go.uber.org/automaxprocs"
 )
 
+// auditFlushTimeout bounds the flush of the buffered audit records on shutdown.
+const auditFlushTimeout = 10 * time.Second
+
 // go build -ldflags "-X main.Version=x.y.z"
 var (
 	Name    string
@@ -17,19 +25,47 @@ var (
 	id, _   = os.Hostname()
 )
 
-func newApp(ctx context.Context, hs *http.Server) *kratos.App {
+func newApp(ctx context.Context, hs *http.Server, auditLogger *audit.Logger) *kratos.App {
+	adminServer := admin.MustCreateServer(Name, Version)
+
 	return kratos.New(
 		kratos.ID(id),
 		kratos.Name(Name),
 		kratos.Version(Version),
 		kratos.Metadata(map[string]string{}),
 		kratos.Logger(logger.MainLogger().Logger()),
-		kratos.Server(hs),
+		kratos.Server(hs, adminServer),
+		kratos.AfterStart(func(context.Context) error {
+			adminServer.SetReady(true)
+
+			return nil
+		}),
+		kratos.BeforeStop(func(context.Context) error {
+			adminServer.SetReady(false)
+
+			return nil
+		}),
+		kratos.AfterStop(func(ctx context.Context) error {
+			if auditLogger == nil {
+				return nil
+			}
+
+			// The context of the app is canceled once it stops.
+			ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), auditFlushTimeout)
+			defer cancel()
+
+			return auditLogger.Close(ctx)
+		}),
 		kratos.Context(ctx),
 	)
 }
 
 func main() {
+	shutdownTracing := tracing.MustInitTracerProvider(context.Background(), Name, Version)
+	defer shutdownTracing()
+
+	metric.MustInitMeterProvider(Name, Version)
+
 	app, cleanup, err := wireApp()
 	if err != nil {
 		panic(err)
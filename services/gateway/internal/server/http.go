@@ -4,7 +4,8 @@ import (
 	gatewayv1 "api/gateway/v1"
 	"context"
 	"gateway/internal/service"
-	"platform/metric"
+	"platform/audit"
+	"platform/cache"
 	"platform/middleware"
 	"time"
 
@@ -12,16 +13,47 @@ import (
 
 	"github.com/caarlos0/env/v11"
 	"github.com/go-kratos/kratos/contrib/middleware/validate/v2"
+	kratosmiddleware "github.com/go-kratos/kratos/v2/middleware"
 	"github.com/go-kratos/kratos/v2/middleware/logging"
-	"github.com/go-kratos/kratos/v2/middleware/ratelimit"
 	"github.com/go-kratos/kratos/v2/middleware/recovery"
+	"github.com/go-kratos/kratos/v2/middleware/tracing"
 	"github.com/go-kratos/kratos/v2/transport/http"
+	"github.com/redis/rueidis"
 )
 
-func mustCreateServer(ctx context.Context) *http.Server {
+// NewAuditLogger creates the logger of the mutating operations when HTTP_AUDIT_ENABLED is set, nil otherwise.
+// It writes to the sink of AUDIT_SINK (see audit.MustCreateSink) and must be closed after the server stops.
+func NewAuditLogger(ctx context.Context) *audit.Logger {
+	type config struct {
+		AuditEnabled bool `env:"HTTP_AUDIT_ENABLED"`
+	}
+
+	cfg, err := env.ParseAs[config]()
+	if err != nil {
+		logger.Fatal(err.Error())
+
+		return nil
+	}
+
+	if !cfg.AuditEnabled {
+		return nil
+	}
+
+	return audit.MustCreateLogger(ctx, audit.MustCreateSink(ctx))
+}
+
+func mustCreateServer(ctx context.Context, auditLogger *audit.Logger) *http.Server {
 	type config struct {
 		Addr    string `env:"HTTP_ADDR"`
 		Network string `env:"HTTP_NETWORK"`
+		// RateLimit is the requests per second of the whole server, clients are limited by RATE_LIMIT_RULES.
+		RateLimit uint64 `env:"HTTP_RATE_LIMIT" envDefault:"50"`
+		// RateLimitShared enforces the limits for all replicas together through redis (see cache.MustCreateRedisClient).
+		RateLimitShared bool `env:"HTTP_RATE_LIMIT_SHARED"`
+		// IdempotencyEnabled stores the responses of requests with an Idempotency-Key in the main cache.
+		IdempotencyEnabled bool `env:"HTTP_IDEMPOTENCY_ENABLED"`
+		// ResponseCacheEnabled caches the responses of the methods with a cache.v1.policy option in the main cache.
+		ResponseCacheEnabled bool `env:"HTTP_RESPONSE_CACHE_ENABLED"`
 	}
 
 	cfg, err := env.ParseAs[config]()
@@ -31,14 +63,64 @@ func mustCreateServer(ctx context.Context) *http.Server {
 		return nil
 	}
 
+	var (
+		redis   rueidis.Client
+		limiter = middleware.ServerRateLimiter(ctx, cfg.RateLimit)
+	)
+
+	if cfg.RateLimitShared {
+		redis = cache.MustCreateRedisClient()
+		limiter = middleware.RedisRateLimiter(ctx, redis, "gateway", cfg.RateLimit)
+	}
+
+	middlewares := []kratosmiddleware.Middleware{
+		middleware.RequestID(),
+		recovery.Recovery(),
+		tracing.Server(),
+		logging.Server(logger.MainLogger().Logger()),
+		middleware.DeadlineServer(),
+	}
+
+	// Audit precedes the middlewares that reject requests, so the denials are logged.
+	if auditLogger != nil {
+		middlewares = append(middlewares, middleware.Audit(auditLogger))
+	}
+
+	// The shedding and the anonymous limiters precede Auth, so the rejected requests cost no token parsing
+	// or JWKS fetch; the priority rules with a role do not match there. Only the limits by user and tenant follow it.
+	middlewares = append(
+		middlewares,
+		middleware.MustCreatePrioritize(),
+		middleware.MustCreateLoadShedding(),
+		middleware.MustCreateKeyedRateLimiter(ctx, redis, middleware.AnonymousRateLimitKeys...),
+		middleware.RateLimitServer(limiter),
+		middleware.MustCreateAuth(ctx, mustLoadAuthPolicies("gateway.v1.GatewayService")),
+		middleware.MustCreateTenant(),
+		middleware.MustCreateKeyedRateLimiter(ctx, redis, middleware.SubjectRateLimitKeys...),
+		middleware.MetricForServer("gateway"),
+		validate.ProtoValidate(),
+	)
+
+	var mainCache cache.Cache
+	if cfg.ResponseCacheEnabled || cfg.IdempotencyEnabled {
+		mainCache = cache.MustCreateMainCache()
+	}
+
+	if cfg.ResponseCacheEnabled {
+		middlewares = append(middlewares, middleware.MustCreateResponseCache(
+			mainCache,
+			mustLoadCachePolicies("gateway.v1.GatewayService"),
+		))
+	}
+
+	if cfg.IdempotencyEnabled {
+		middlewares = append(middlewares, middleware.MustCreateIdempotency(mainCache))
+	}
+
 	var opts = []http.ServerOption{
-		http.Middleware(
-			recovery.Recovery(),
-			logging.Server(logger.MainLogger().Logger()),
-			ratelimit.Server(ratelimit.WithLimiter(middleware.ServerRateLimiter(ctx, 50))),
-			middleware.MetricForServer("gateway"),
-			validate.ProtoValidate(),
-		),
+		http.Middleware(middlewares...),
+		http.ResponseEncoder(middleware.ResponseEncoder),
+		http.Filter(middleware.MustCreateHTTPFilters()...),
 	}
 
 	if cfg.Network == "" {
@@ -61,12 +143,10 @@ func mustCreateServer(ctx context.Context) *http.Server {
 }
 
 // NewHTTPServer new an HTTP server.
-func NewHTTPServer(ctx context.Context, s *service.Services) *http.Server {
-	srv := mustCreateServer(ctx)
+func NewHTTPServer(ctx context.Context, s *service.Services, auditLogger *audit.Logger) *http.Server {
+	srv := mustCreateServer(ctx, auditLogger)
 
 	gatewayv1.RegisterGatewayServiceHTTPServer(srv, s)
 
-	metric.RegisterMetricHTTPEndpoint(srv)
-
 	return srv
 }
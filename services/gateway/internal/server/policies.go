@@ -0,0 +1,79 @@
+package server
+
+import (
+	authv1 "api/auth/v1"
+	cachev1 "api/cache/v1"
+	"fmt"
+	"platform/logger"
+	"platform/middleware"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+)
+
+// mustLoadAuthPolicies reads the auth.v1.policy options of the service methods by kratos operation.
+func mustLoadAuthPolicies(serviceName protoreflect.FullName) map[string]middleware.AuthPolicy {
+	policies := make(map[string]middleware.AuthPolicy)
+
+	for operation, method := range mustFindMethods(serviceName) {
+		policy, _ := proto.GetExtension(method.Options(), authv1.E_Policy).(*authv1.Policy)
+
+		switch policy.GetAccess() {
+		case authv1.Access_ACCESS_PUBLIC:
+			policies[operation] = middleware.AuthPolicy{Access: middleware.AccessPublic}
+		case authv1.Access_ACCESS_ROLES:
+			policies[operation] = middleware.AuthPolicy{Access: middleware.AccessRoles, Roles: policy.GetRoles()}
+		default:
+			policies[operation] = middleware.AuthPolicy{Access: middleware.AccessAuthenticated}
+		}
+	}
+
+	return policies
+}
+
+// mustLoadCachePolicies reads the cache.v1.policy options of the service methods by kratos operation.
+func mustLoadCachePolicies(serviceName protoreflect.FullName) map[string]middleware.ResponseCachePolicy {
+	policies := make(map[string]middleware.ResponseCachePolicy)
+
+	for operation, method := range mustFindMethods(serviceName) {
+		policy, _ := proto.GetExtension(method.Options(), cachev1.E_Policy).(*cachev1.Policy)
+		if policy.GetMaxAgeSeconds() == 0 {
+			continue
+		}
+
+		policies[operation] = middleware.ResponseCachePolicy{
+			MaxAge:      time.Duration(policy.GetMaxAgeSeconds()) * time.Second,
+			VaryHeaders: policy.GetVaryHeaders(),
+		}
+	}
+
+	return policies
+}
+
+// mustFindMethods returns the methods of the service by kratos operation.
+func mustFindMethods(serviceName protoreflect.FullName) map[string]protoreflect.MethodDescriptor {
+	desc, err := protoregistry.GlobalFiles.FindDescriptorByName(serviceName)
+	if err != nil {
+		logger.Fatalf("error occurred when finding service %s: %v", serviceName, err)
+
+		return nil
+	}
+
+	service, ok := desc.(protoreflect.ServiceDescriptor)
+	if !ok {
+		logger.Fatalf("%s is not a service", serviceName)
+
+		return nil
+	}
+
+	methods := make(map[string]protoreflect.MethodDescriptor, service.Methods().Len())
+
+	for i := range service.Methods().Len() {
+		method := service.Methods().Get(i)
+		methods[fmt.Sprintf("/%s/%s", service.FullName(), method.Name())] = method
+	}
+
+	return methods
+}